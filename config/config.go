@@ -36,10 +36,18 @@ type Etcd struct {
 }
 
 type Service struct {
-	Name        string   `yaml:"name"`
-	LoadBalance bool     `yaml:"loadBalance"`
-	Addr        []string `yaml:"addr"`
-	TTL         int      `yaml:"ttl"`
+	Name         string   `yaml:"name"`
+	LoadBalance  bool     `yaml:"loadBalance"`
+	Addr         []string `yaml:"addr"`
+	TTL          int      `yaml:"ttl"`
+	LeaseTTL     int      `yaml:"leaseTTL"`     // second, lease of the registration, 0 means the etcd ttl
+	Registry     string   `yaml:"registry"`     // etcd (default), static or file
+	RegistryFile string   `yaml:"registryFile"` // path of the file registry
+	Weight       int      `yaml:"weight"`       // share of keys of this node, 0 means registry.DefaultWeight
+	LoadBound    float64  `yaml:"loadBound"`    // epsilon of bounded-load consistent hashing, 0 disables it
+	Selector     string   `yaml:"selector"`     // key placement: consistent (default), rendezvous, jump or maglev
+	Replicas     int      `yaml:"replicas"`     // replication factor, number of nodes holding each key, 0 means 1
+	HandoffLimit int      `yaml:"handoffLimit"` // entries of each group pushed to their new owners on ring changes and shutdown, 0 disables it
 }
 
 type Domain struct {
@@ -47,8 +55,34 @@ type Domain struct {
 }
 
 type GroupManager struct {
-	Strategy     string `yaml:"strategy"`
-	MaxCacheSize int64  `yaml:"maxCacheSize"`
+	Strategy      string              `yaml:"strategy"`
+	MaxCacheSize  int64               `yaml:"maxCacheSize"`
+	TTL           int                 `yaml:"ttl"`           // second, 0 means entries never expire
+	WriteOnFill   bool                `yaml:"writeOnFill"`   // push values loaded by the owner to the replicas of the key
+	NegativeTTL   int                 `yaml:"negativeTTL"`   // second, lifetime of the entries of keys missing from the database, 0 disables them
+	BloomFilter   BloomFilter         `yaml:"bloomFilter"`   // filter of the keys of the database, answering lookups of missing keys
+	TTLJitter     float64             `yaml:"ttlJitter"`     // fraction of the ttl entries expire earlier or later by, 0 disables it
+	EarlyRefresh  float64             `yaml:"earlyRefresh"`  // beta of the XFetch early refresh of entries about to expire, 0 disables it
+	StaleTTL      int                 `yaml:"staleTTL"`      // second, past the ttl entries are served stale while refreshed in the background
+	MaxStaleness  int                 `yaml:"maxStaleness"`  // second, past the ttl entries are served stale when the database fails
+	HotCacheShare float64             `yaml:"hotCacheShare"` // share of MaxCacheSize copying hot values of peers, 0 disables the hot cache
+	HotCacheTTL   int                 `yaml:"hotCacheTTL"`   // second, lifetime of a hot copy, 0 means the default
+	TopK          int                 `yaml:"topK"`          // most requested keys reported per group, 0 means the default
+	HotKeyLogAt   int64               `yaml:"hotKeyLogAt"`   // requests after which a key is logged as hot, 0 disables it
+	Snapshot      Snapshot            `yaml:"snapshot"`      // snapshot of the main cache of every group
+	Snapshots     map[string]Snapshot `yaml:"snapshots"`     // snapshot settings overriding Snapshot, by group
+}
+
+// BloomFilter configures the Bloom filter built from the keys of the database.
+type BloomFilter struct {
+	FPRate  float64 `yaml:"fpRate"`  // false positive rate, 0 disables the filter
+	Rebuild int     `yaml:"rebuild"` // second, between two rebuilds picking up keys inserted behind the cache, 0 never rebuilds
+}
+
+// Snapshot configures the snapshot file reloaded by a group when the node restarts.
+type Snapshot struct {
+	Dir      string `yaml:"dir"`      // directory of the <group>.snap files, empty disables snapshots
+	Interval int    `yaml:"interval"` // second, between two snapshots, 0 only writes one on shutdown
 }
 
 func InitConfig() {
@@ -32,6 +32,29 @@ func (db *CnfMetricDb) ShowCnfMetric(req *cnfmetricspb.CnfMetricRequest) (*model
     return &metric, nil
 }
 
+// ListCnfMetrics retrieves the CNF Metric records of many CNF IDs with a single query.
+// IDs without a record are simply missing from the result.
+func (db *CnfMetricDb) ListCnfMetrics(cnfIds []string) ([]model.CnfMetric, error) {
+    var metrics []model.CnfMetric
+    err := db.Model(&model.CnfMetric{}).Where("cnf_id IN ?", cnfIds).Find(&metrics).Error
+    if err != nil {
+        loggerInstance.Errorf("Failed to retrieve %d CNF metrics: %v", len(cnfIds), err)
+        return nil, err
+    }
+    return metrics, nil
+}
+
+// ListCnfIds returns the CNF IDs of all the CNF Metric records.
+func (db *CnfMetricDb) ListCnfIds() ([]string, error) {
+    var cnfIds []string
+    err := db.Model(&model.CnfMetric{}).Distinct().Pluck("cnf_id", &cnfIds).Error
+    if err != nil {
+        loggerInstance.Errorf("Failed to list CNF IDs: %v", err)
+        return nil, err
+    }
+    return cnfIds, nil
+}
+
 // CreateCnfMetric inserts a new CNF Metric record into the database.
 func (db *CnfMetricDb) CreateCnfMetric(req *cnfmetricspb.CreateCnfMetricRequest) error {
     // Map the proto message to the database model.
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	minBloomKeys  = 1024 // keys a Bloom filter is sized for at least
+	bloomHeadroom = 2    // a filter is sized for this many times the keys listed, leaving room for inserts
+)
+
+// bloomFilter is a Bloom filter over string keys. It answers whether a key may
+// have been added, with no false negatives and a false positive rate set at
+// creation. Its k bit positions derive from one 64-bit hash by double hashing.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// newBloomFilter creates a filter for n keys with a false positive rate of fpRate.
+func newBloomFilter(n int, fpRate float64) *bloomFilter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: max(k, 1)}
+}
+
+// positions returns the first hash and the step of the bit positions of key.
+func (b *bloomFilter) positions(key string) (uint64, uint64) {
+	h := hash64(key)
+	return h, mix64(h) | 1 // an odd step visits distinct positions
+}
+
+// add records key.
+func (b *bloomFilter) add(key string) {
+	h, step := b.positions(key)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h + i*step) % b.m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+// mayContain reports whether key may have been added. False means it was not.
+func (b *bloomFilter) mayContain(key string) bool {
+	h, step := b.positions(key)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h + i*step) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// keyFilter guards a group against keys missing from the backing store with a
+// Bloom filter of the keys listed by keys, rebuilt from time to time so that
+// keys inserted behind the back of the cache become visible, and deleted keys
+// stop taking room.
+type keyFilter struct {
+	keys   KeyLister
+	fpRate float64
+	stop   chan struct{} // stops the periodic rebuild
+
+	mu      sync.RWMutex // protects bloom and pending
+	bloom   *bloomFilter
+	pending []string // keys added during a rebuild, nil when not rebuilding
+}
+
+// mayContain reports whether key may exist in the backing store.
+func (f *keyFilter) mayContain(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.bloom.mayContain(key)
+}
+
+// add records that key exists in the backing store.
+func (f *keyFilter) add(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bloom.add(key)
+	if f.pending != nil {
+		f.pending = append(f.pending, key)
+	}
+}
+
+// rebuild replaces the filter by one built from the keys listed now. Keys added
+// while listing are carried over, they may not be listed yet.
+func (f *keyFilter) rebuild() error {
+	f.mu.Lock()
+	f.pending = []string{}
+	f.mu.Unlock()
+
+	keys, err := f.keys.listKeys()
+	if err != nil {
+		f.mu.Lock()
+		f.pending = nil
+		f.mu.Unlock()
+		return err
+	}
+
+	bloom := newBloomFilter(max(len(keys), minBloomKeys)*bloomHeadroom, f.fpRate)
+	for _, key := range keys {
+		bloom.add(key)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range f.pending {
+		bloom.add(key)
+	}
+	f.bloom, f.pending = bloom, nil
+	return nil
+}
+
+// SetBloomFilter guards the group against lookups of keys missing from the
+// backing store: a Bloom filter of the keys listed by keys, with a false
+// positive rate of fpRate, answers them with ErrNotFound without asking the
+// retriever. Keys written with Set are added to the filter. Keys inserted into
+// the backing store without going through the cache are seen once the filter
+// is rebuilt, every rebuild if above 0. It must be called before the group
+// serves requests, it fails if the keys cannot be listed.
+func (g *Group) SetBloomFilter(keys KeyLister, fpRate float64, rebuild time.Duration) error {
+	if fpRate <= 0 || fpRate >= 1 {
+		return fmt.Errorf("bloom filter false positive rate must be in (0, 1), got %v", fpRate)
+	}
+	f := &keyFilter{keys: keys, fpRate: fpRate}
+	if err := f.rebuild(); err != nil {
+		return fmt.Errorf("failed to list the keys of the bloom filter: %w", err)
+	}
+
+	g.stopBloomFilter()
+	g.filter = f
+	if rebuild <= 0 {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	f.stop = stop
+	go func() {
+		ticker := time.NewTicker(rebuild)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := f.rebuild(); err != nil {
+					loggerInstance.Warnf("Group %s failed to rebuild its bloom filter: %v", g.name, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// stopBloomFilter stops rebuilding the Bloom filter periodically.
+func (g *Group) stopBloomFilter() {
+	if g.filter != nil && g.filter.stop != nil {
+		close(g.filter.stop)
+		g.filter.stop = nil
+	}
+}
+
+// mayExist reports whether key may exist in the backing store, always true
+// without a Bloom filter.
+func (g *Group) mayExist(key string) bool {
+	return g.filter == nil || g.filter.mayContain(key)
+}
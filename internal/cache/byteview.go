@@ -7,6 +7,13 @@ import "time"
 type ByteView struct {
 	b        []byte    // Actual bytes stored
 	expireAt time.Time // 过期时间，零值表示永不过期
+	version  string    // version or ETag reported by the loader, may be empty
+
+	staleUntil time.Time // the value may be served stale until then, see Group.SetStaleTTL
+	stale      bool      // the value was served past its expiry
+	notFound   bool      // the key is missing from the backing store, see Group.SetNegativeTTL
+
+	loadTime time.Duration // time the retriever took to load the value, weighs its early refresh
 }
 
 // Len returns the view's length.
@@ -30,9 +37,31 @@ func (v ByteView) Bytes() []byte {
 	return v.b
 }
 
+// ExpireAt returns the absolute expiry time, the zero time means never.
+func (v ByteView) ExpireAt() time.Time {
+	return v.expireAt
+}
+
+// Version returns the version or ETag of the value, if the loader reported one.
+func (v ByteView) Version() string {
+	return v.version
+}
+
+// Stale reports whether the value was served past its expiry, because it was
+// being revalidated or could not be loaded again.
+func (v ByteView) Stale() bool {
+	return v.stale
+}
+
 // IsExpired 检查值是否已过期
+// A value past its expiry that may still be served stale has not expired yet.
 func (v ByteView) IsExpired() bool {
 	// 零值时间表示永不过期
+	return !v.expireAt.IsZero() && time.Now().After(v.expireAt) && !time.Now().Before(v.staleUntil)
+}
+
+// needsRefresh reports whether the value is past its expiry, stale or expired.
+func (v ByteView) needsRefresh() bool {
 	return !v.expireAt.IsZero() && time.Now().After(v.expireAt)
 }
 
@@ -43,7 +43,8 @@ func NewCache(strategy string, maxBytes int64) (*cache, error) {
 	}, nil
 }
 
-// It returns the value and whether the key was found.
+// get looks up a key in the cache.
+// It returns the value and whether the key was found and has not expired.
 func (c *cache) get(key string) (ByteView, bool) {
 	if c == nil {
 		return ByteView{}, false
@@ -54,19 +55,36 @@ func (c *cache) get(key string) (ByteView, bool) {
 		metrics.ObserveRequestDuration("get", time.Since(start).Seconds()*1000)
 	}()
 
+	if bv, ok := c.lookup(key); ok {
+		metrics.RecordCacheHit()
+		return bv, true
+	}
+	// cache miss happens when retrieving from db
+	loggerInstance.Debugf("RecordCacheMiss for key=%s", key)
+	metrics.RecordCacheMiss()
+	return ByteView{}, false
+}
+
+// lookup is get without recording a hit or a miss.
+func (c *cache) lookup(key string) (ByteView, bool) {
+	if c == nil {
+		return ByteView{}, false
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if v, _, exists := c.strategy.Get(key); exists {
 		if bv, ok := v.(ByteView); ok {
-			metrics.RecordCacheHit()
-			return bv, true
+			if !bv.IsExpired() {
+				return bv, true
+			}
+			// expired entries are misses, the strategy reaps them in its cleanup routine
+			loggerInstance.Debugf("Cache entry expired: key=%s, expireAt=%v", key, bv.ExpireAt())
+		} else {
+			loggerInstance.Warnf("Invalid cache value type for key=%s", key)
 		}
-		loggerInstance.Warnf("Invalid cache value type for key=%s", key)
 	}
-	// cache miss happens when retrieving from db
-	loggerInstance.Debugf("RecordCacheMiss for key=%s", key)
-	metrics.RecordCacheMiss()
 	return ByteView{}, false
 }
 
@@ -83,3 +101,56 @@ func (c *cache) put(key string, value ByteView) {
 	loggerInstance.Infof("Update to cache: key=%s, value=%v", key, value)
 	c.strategy.Put(key, value)
 }
+
+// remove deletes a key from the cache.
+// It reports whether the key was present.
+func (c *cache) remove(key string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	loggerInstance.Infof("Remove from cache: key=%s", key)
+	return c.strategy.Delete(key)
+}
+
+// contains reports whether key holds a value that has not expired, without
+// counting it as a hit or a miss.
+func (c *cache) contains(key string) bool {
+	_, ok := c.lookup(key)
+	return ok
+}
+
+// entries returns the values of the cache that have not expired,
+// from the most to the least recently used. Keys cached as missing from
+// the backing store are left out.
+func (c *cache) entries() []eviction.Entry {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := c.strategy.Entries()
+	live := entries[:0]
+	for _, e := range entries {
+		if bv, ok := e.Value.(ByteView); ok && !bv.IsExpired() && !bv.notFound {
+			live = append(live, e)
+		}
+	}
+	return live
+}
+
+// stop stops the cleanup routine of the eviction strategy, if it has one.
+// The cache must not be used afterwards.
+func (c *cache) stop() {
+	if c == nil {
+		return
+	}
+	if s, ok := c.strategy.(interface{ Stop() }); ok {
+		s.Stop()
+	}
+}
@@ -3,9 +3,14 @@ package cache
 
 import (
 	"hash/crc32"
+	"math"
+	"slices"
 	"sort"
 	"strconv"
 	"sync"
+
+	"distcache/internal/metrics"
+	"distcache/pkg/registry"
 )
 
 // Hash defines a function that generates a hash value for the given data.
@@ -14,13 +19,21 @@ type Hash func(data []byte) uint32
 // ConsistentMap implements consistent hashing to distribute keys across nodes.
 // It maintains a ring of virtual nodes to ensure even distribution.
 type ConsistentMap struct {
-	mu       sync.RWMutex
-	hash     Hash           // hash function to use
-	replicas int            // number of virtual nodes per real node
-	keys     []int          // sorted list of hash keys
-	hashMap  map[int]string // maps virtual nodes to real nodes
+	mu           sync.RWMutex
+	hash         Hash           // hash function to use
+	replicas     int            // number of virtual nodes per real node of default weight
+	keys         []int          // sorted list of hash keys
+	hashMap      map[int]string // maps virtual nodes to real nodes
+	nodeReplicas map[string]int // number of virtual nodes of each real node
+
+	// Bounded loads, disabled while load is nil
+	epsilon float64  // a node may take (1+epsilon) times its share of the load
+	load    LoadFunc // current load of a node, e.g. its in-flight requests
 }
 
+// LoadFunc reports the current load of a node, such as its in-flight requests.
+type LoadFunc func(node string) int64
+
 // NewConsistentHash creates a ConsistentMap with the specified number of replicas
 // and an optional hash function. If fn is nil, uses crc32.ChecksumIEEE.
 func NewConsistentHash(replicas int, fn Hash) *ConsistentMap {
@@ -29,9 +42,10 @@ func NewConsistentHash(replicas int, fn Hash) *ConsistentMap {
 	}
 
 	m := &ConsistentMap{
-		replicas: replicas,
-		hash:     fn,
-		hashMap:  make(map[int]string),
+		replicas:     replicas,
+		hash:         fn,
+		hashMap:      make(map[int]string),
+		nodeReplicas: make(map[string]int),
 	}
 
 	if m.hash == nil {
@@ -51,16 +65,63 @@ func (m *ConsistentMap) AddNodes(nodes ...string) {
 	defer m.mu.Unlock()
 
 	for _, node := range nodes {
-		for i := 0; i < m.replicas; i++ {
-			hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = node
-		}
+		m.addLocked(node, m.replicas)
+	}
+	sort.Ints(m.keys)
+}
+
+// AddWeightedNode adds node to the hash ring with a number of virtual nodes
+// proportional to its weight, so that it owns a matching share of the keys.
+// A node of registry.DefaultWeight gets the configured number of replicas,
+// every node gets at least one. A weight of 0 or less means the default weight.
+func (m *ConsistentMap) AddWeightedNode(node string, weight int) {
+	if node == "" {
+		return
+	}
+	if weight <= 0 {
+		weight = registry.DefaultWeight
 	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addLocked(node, max(1, m.replicas*weight/registry.DefaultWeight))
 	sort.Ints(m.keys)
 }
 
+// addLocked adds count virtual nodes for node, replacing those node already had,
+// m.mu must be held and the keys sorted afterwards.
+func (m *ConsistentMap) addLocked(node string, count int) {
+	if _, ok := m.nodeReplicas[node]; ok {
+		m.removeLocked(node)
+	}
+	for i := 0; i < count; i++ {
+		hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = node
+	}
+	m.nodeReplicas[node] = count
+}
+
+// SetLoadBound enables consistent hashing with bounded loads. A node is
+// considered full once its load reaches (1+epsilon) times its share of the
+// total load, its share being proportional to its virtual nodes. GetNode then
+// walks the ring past full nodes to the next one with spare capacity, so a hot
+// key spills over instead of pinning its owner. Keys of nodes below their cap
+// keep their owner. An epsilon of 0 or less, or a nil load, disables the bound.
+func (m *ConsistentMap) SetLoadBound(epsilon float64, load LoadFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if epsilon <= 0 || load == nil {
+		m.epsilon, m.load = 0, nil
+		return
+	}
+	m.epsilon, m.load = epsilon, load
+}
+
 // GetNode returns the node responsible for the given key.
+// With a load bound, it is the first node from the owner on with spare capacity.
 // Returns empty string if the hash ring is empty or key is invalid.
 func (m *ConsistentMap) GetNode(key string) string {
 	if key == "" || m == nil {
@@ -84,9 +145,95 @@ func (m *ConsistentMap) GetNode(key string) string {
 		idx = 0
 	}
 
+	if m.load != nil {
+		return m.boundedNode(idx)
+	}
 	return m.hashMap[m.keys[idx]]
 }
 
+// GetNodes returns up to n distinct nodes for key: its owner, ignoring any
+// load bound, followed by the next distinct nodes clockwise on the ring.
+func (m *ConsistentMap) GetNodes(key string, n int) []string {
+	if key == "" || m == nil || n <= 0 {
+		return nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.keys) == 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	n = min(n, len(m.nodeReplicas))
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// boundedNode returns the node at idx if its load stays within its cap when
+// taking one more request. Otherwise it walks the ring from there and returns
+// the first node that does. If every node is full, which the cap makes
+// impossible unless loads change while walking, the owner is returned.
+// m.mu must be held.
+func (m *ConsistentMap) boundedNode(idx int) string {
+	owner := m.hashMap[m.keys[idx]]
+	load := max(m.load(owner), 0)
+
+	// The cap grows with the total load: once the owner fits under a partial
+	// total it fits under the whole one, so most lookups ask few nodes
+	total := load
+	if m.fits(owner, load, total) {
+		return owner
+	}
+	for node := range m.nodeReplicas {
+		if node == owner {
+			continue
+		}
+		total += max(m.load(node), 0)
+		if m.fits(owner, load, total) {
+			return owner
+		}
+	}
+
+	var buf [8]string
+	full := append(buf[:0], owner)
+	for i := 1; i < len(m.keys) && len(full) < len(m.nodeReplicas); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if slices.Contains(full, node) {
+			continue
+		}
+		if m.fits(node, max(m.load(node), 0), total) {
+			metrics.RecordLoadRedirect()
+			return node
+		}
+		full = append(full, node)
+	}
+	return owner
+}
+
+// fits reports whether node, carrying load out of total, may take one more request.
+// m.mu must be held.
+func (m *ConsistentMap) fits(node string, load, total int64) bool {
+	return float64(load+1) <= m.capacity(node, total+1)
+}
+
+// capacity returns the most load node may take out of total, m.mu must be held.
+func (m *ConsistentMap) capacity(node string, total int64) float64 {
+	share := float64(m.nodeReplicas[node]) / float64(len(m.keys))
+	return math.Ceil((1 + m.epsilon) * float64(total) * share)
+}
+
 // RemoveNode removes a node and its replicas from the hash ring.
 // This operation is safe even if the node doesn't exist.
 func (m *ConsistentMap) RemoveNode(node string) {
@@ -96,10 +243,20 @@ func (m *ConsistentMap) RemoveNode(node string) {
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.removeLocked(node)
+}
+
+// removeLocked removes the virtual nodes of node, m.mu must be held.
+func (m *ConsistentMap) removeLocked(node string) {
+	count, ok := m.nodeReplicas[node]
+	if !ok {
+		count = m.replicas
+	}
+	delete(m.nodeReplicas, node)
 
 	// Find all hashes for this node's replicas
-	hashesToRemove := make([]int, 0, m.replicas)
-	for i := 0; i < m.replicas; i++ {
+	hashesToRemove := make([]int, 0, count)
+	for i := 0; i < count; i++ {
 		hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
 		if _, exists := m.hashMap[hash]; exists {
 			hashesToRemove = append(hashesToRemove, hash)
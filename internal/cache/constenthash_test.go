@@ -1,8 +1,11 @@
 package cache
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"hash/crc32"
+	"math"
 	"strconv"
 	"testing"
 )
@@ -204,3 +207,165 @@ func TestConsistentHash(t *testing.T) {
 		})
 	}
 }
+
+// sha256Hash spreads similar inputs evenly, so that shares of the ring can be measured.
+func sha256Hash(data []byte) uint32 {
+	sum := sha256.Sum256(data)
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func TestConsistentHash_AddWeightedNode(t *testing.T) {
+	ch := NewConsistentHash(50, sha256Hash)
+	ch.AddWeightedNode("127.0.0.1:9999", 10)
+	ch.AddWeightedNode("127.0.0.1:10000", 10)
+	ch.AddWeightedNode("127.0.0.1:10001", 20)
+	ch.AddWeightedNode("127.0.0.1:10002", 0) // default weight
+
+	if want := 50 + 50 + 100 + 50; len(ch.keys) != want {
+		t.Fatalf("got %d keys, want %d", len(ch.keys), want)
+	}
+
+	// The node of double weight should own about twice the keys of the others
+	const total = 100000
+	owned := make(map[string]int)
+	for i := 0; i < total; i++ {
+		owned[ch.GetNode("key"+strconv.Itoa(i))]++
+	}
+	heavy := float64(owned["127.0.0.1:10001"]) / total
+	if heavy < 0.3 || heavy > 0.5 {
+		t.Errorf("node of weight 20 owns %.2f of the keys, want about 0.4 (%v)", heavy, owned)
+	}
+
+	// Removing a weighted node removes all of its virtual nodes
+	ch.RemoveNode("127.0.0.1:10001")
+	if want := 150; len(ch.keys) != want {
+		t.Errorf("after removing the weighted node got %d keys, want %d", len(ch.keys), want)
+	}
+	// Reweighing a node replaces its virtual nodes, removing it leaves none behind
+	ch.AddWeightedNode("127.0.0.1:9999", 30)
+	ch.AddWeightedNode("127.0.0.1:9999", 20)
+	if want := 100 + 50 + 50; len(ch.keys) != want || len(ch.hashMap) != want {
+		t.Errorf("after reweighing got %d keys and %d virtual nodes, want %d", len(ch.keys), len(ch.hashMap), want)
+	}
+	ch.RemoveNode("127.0.0.1:9999")
+	for _, node := range ch.hashMap {
+		if node == "127.0.0.1:9999" {
+			t.Fatal("removed node still owns virtual nodes after a reweigh")
+		}
+	}
+}
+
+// assign places keys one after another, counting every key as one unit of load
+// of the node it is placed on, and returns the load of each node.
+func assign(ch *ConsistentMap, loads map[string]int64, keys int) {
+	for i := 0; i < keys; i++ {
+		loads[ch.GetNode("key"+strconv.Itoa(i))]++
+	}
+}
+
+func TestConsistentHash_Distribution(t *testing.T) {
+	nodes := []string{"127.0.0.1:9999", "127.0.0.1:10000", "127.0.0.1:10001"}
+	const keys = 30000
+
+	// Without a bound, the share of each node depends on where its virtual nodes land
+	plain := NewConsistentHash(50, nil)
+	plain.AddNodes(nodes...)
+	plainLoads := make(map[string]int64)
+	assign(plain, plainLoads, keys)
+	t.Logf("unbounded loads: %v", plainLoads)
+
+	for _, epsilon := range []float64{0.05, 0.25, 1} {
+		ch := NewConsistentHash(50, nil)
+		ch.AddNodes(nodes...)
+		loads := make(map[string]int64)
+		ch.SetLoadBound(epsilon, func(node string) int64 { return loads[node] })
+		assign(ch, loads, keys)
+
+		limit := int64(math.Ceil((1 + epsilon) * keys / float64(len(nodes))))
+		for node, load := range loads {
+			if load > limit {
+				t.Errorf("epsilon %v: %s holds %d keys, want at most %d (%v)", epsilon, node, load, limit, loads)
+			}
+		}
+		t.Logf("epsilon %v loads: %v", epsilon, loads)
+	}
+}
+
+func TestConsistentHash_LoadBoundWeighted(t *testing.T) {
+	ch := NewConsistentHash(50, sha256Hash)
+	ch.AddWeightedNode("light", 10)
+	ch.AddWeightedNode("heavy", 30)
+	loads := make(map[string]int64)
+	ch.SetLoadBound(0.1, func(node string) int64 { return loads[node] })
+	assign(ch, loads, 8000)
+
+	// The cap follows the share of virtual nodes, 1/4 and 3/4
+	if limit := int64(math.Ceil(1.1 * 8000 / 4)); loads["light"] > limit {
+		t.Errorf("light node holds %d keys, want at most %d (%v)", loads["light"], limit, loads)
+	}
+	if limit := int64(math.Ceil(1.1 * 8000 * 3 / 4)); loads["heavy"] > limit {
+		t.Errorf("heavy node holds %d keys, want at most %d (%v)", loads["heavy"], limit, loads)
+	}
+}
+
+func TestConsistentHash_LoadBoundHotKey(t *testing.T) {
+	ch := NewConsistentHash(50, sha256Hash)
+	ch.AddNodes("A", "B", "C")
+	owner := ch.GetNode("hot")
+
+	// Requests of one hot key in flight at once spill over to other nodes
+	inflight := make(map[string]int64)
+	ch.SetLoadBound(0.25, func(node string) int64 { return inflight[node] })
+	for i := 0; i < 12; i++ {
+		inflight[ch.GetNode("hot")]++
+	}
+	if len(inflight) < 2 || inflight[owner] > 5 {
+		t.Errorf("in-flight requests of a hot key = %v, want the owner %s capped at 5 and the rest spread", inflight, owner)
+	}
+
+	// Once the requests completed the key returns to its owner
+	clear(inflight)
+	if got := ch.GetNode("hot"); got != owner {
+		t.Errorf("GetNode() without load = %q, want the owner %q", got, owner)
+	}
+
+	// Disabling the bound ignores the load
+	inflight[owner] = 100
+	ch.SetLoadBound(0, nil)
+	if got := ch.GetNode("hot"); got != owner {
+		t.Errorf("GetNode() without bound = %q, want the owner %q", got, owner)
+	}
+}
+
+func TestConsistentHash_LoadBoundOwnerFirst(t *testing.T) {
+	ch := NewConsistentHash(50, sha256Hash)
+	ch.AddNodes("A", "B", "C")
+	owner := ch.GetNode("key")
+
+	// An owner with spare capacity is returned without asking the other nodes
+	var asked []string
+	ch.SetLoadBound(0.25, func(node string) int64 {
+		asked = append(asked, node)
+		return 0
+	})
+	if got := ch.GetNode("key"); got != owner || len(asked) != 1 {
+		t.Errorf("GetNode() = %q asking %v, want the owner %q asking only it", got, asked, owner)
+	}
+
+	// Neither does walking past a full owner allocate
+	ch.SetLoadBound(0.25, func(node string) int64 {
+		if node == owner {
+			return 10
+		}
+		return 0
+	})
+	if got := ch.GetNode("key"); got == owner {
+		t.Errorf("GetNode() = %q, want a node other than the full owner", got)
+	}
+	plain := NewConsistentHash(50, sha256Hash)
+	plain.AddNodes("A", "B", "C")
+	want := testing.AllocsPerRun(100, func() { plain.GetNode("key") })
+	if allocs := testing.AllocsPerRun(100, func() { ch.GetNode("key") }); allocs > want {
+		t.Errorf("GetNode() with a load bound allocates %v times, want %v like without", allocs, want)
+	}
+}
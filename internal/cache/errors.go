@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Errors returned by groups and peers, possibly wrapped: test them with errors.Is.
+// They travel between peers as gRPC status codes and HTTP status codes.
+var (
+	// ErrNotFound is returned for keys missing from the backing store. Retrievers
+	// report such keys with ErrNotFound or gorm.ErrRecordNotFound, possibly wrapped.
+	ErrNotFound = errors.New("key not found")
+
+	// ErrNoSuchGroup is returned by peers asked for a group they do not serve.
+	ErrNoSuchGroup = errors.New("no such group")
+
+	// ErrInvalidKey is returned for empty keys.
+	ErrInvalidKey = errors.New("invalid key")
+
+	// ErrPeerUnavailable is returned when a peer cannot be reached or did not answer in time.
+	ErrPeerUnavailable = errors.New("peer unavailable")
+)
+
+// isNotFound reports whether err reports a key missing from the backing store.
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+// toStatus converts err into a gRPC status error with the code of the
+// sentinel error it wraps, so that the peer can tell them apart.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	code := codes.Unknown
+	switch {
+	case isNotFound(err):
+		code = codes.NotFound
+	case errors.Is(err, ErrInvalidKey):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrNoSuchGroup):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ErrPeerUnavailable):
+		code = codes.Unavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
+
+// fromStatus converts an error returned by a gRPC call to a peer back into
+// the sentinel error of its status code. Calls that failed without a status,
+// such as a peer that cannot be dialed, are reported as ErrPeerUnavailable.
+// A call past its deadline is reported as both ErrPeerUnavailable and
+// context.DeadlineExceeded, a canceled call as context.Canceled.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return fmt.Errorf("%w: %w", ErrPeerUnavailable, err)
+	}
+	switch st.Code() {
+	case codes.NotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, st.Message())
+	case codes.InvalidArgument:
+		return fmt.Errorf("%w: %s", ErrInvalidKey, st.Message())
+	case codes.FailedPrecondition:
+		return fmt.Errorf("%w: %s", ErrNoSuchGroup, st.Message())
+	case codes.Unavailable:
+		return fmt.Errorf("%w: %s", ErrPeerUnavailable, st.Message())
+	case codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %w: %s", ErrPeerUnavailable, context.DeadlineExceeded, st.Message())
+	case codes.Canceled:
+		return fmt.Errorf("%w: %s", context.Canceled, st.Message())
+	}
+	return err
+}
+
+// errorHeader names the sentinel error of an HTTP error response whose status
+// code is shared: an unknown group is answered with 404 like a missing key.
+const errorHeader = "X-Groupcache-Error"
+
+// httpStatus returns the HTTP status code reporting err, following the
+// usual mapping of the gRPC codes of toStatus to HTTP.
+func httpStatus(err error) int {
+	switch {
+	case isNotFound(err), errors.Is(err, ErrNoSuchGroup):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidKey):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrPeerUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// writeHTTPError answers a request with err and its HTTP status code.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNoSuchGroup) {
+		w.Header().Set(errorHeader, ErrNoSuchGroup.Error())
+	}
+	http.Error(w, err.Error(), httpStatus(err))
+}
+
+// fromHTTPStatus converts an error response of a peer back into the sentinel
+// error of its status code.
+func fromHTTPStatus(res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		if res.Header.Get(errorHeader) == ErrNoSuchGroup.Error() {
+			return fmt.Errorf("%w: server returned: %v", ErrNoSuchGroup, res.Status)
+		}
+		return fmt.Errorf("%w: server returned: %v", ErrNotFound, res.Status)
+	case http.StatusBadRequest:
+		return fmt.Errorf("%w: server returned: %v", ErrInvalidKey, res.Status)
+	case http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("%w: server returned: %v", ErrPeerUnavailable, res.Status)
+	}
+	return fmt.Errorf("server returned: %v", res.Status)
+}
@@ -0,0 +1,280 @@
+package eviction
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Lists an ARC entry can live in.
+const (
+	listT1 = iota // live entries seen once recently
+	listT2        // live entries seen at least twice recently
+	listB1        // ghosts of entries evicted from T1
+	listB2        // ghosts of entries evicted from T2
+)
+
+// arcItem is either a live cache entry or a ghost that only remembers the
+// key and the number of bytes it used to occupy.
+type arcItem struct {
+	entry *Entry
+	size  int64
+	list  int
+}
+
+// arcSegment represents a portion of the ARC cache with its own lock.
+// All sizes, including the adaptive target p, are measured in bytes.
+type arcSegment struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	p         int64 // target size of T1 in bytes
+	lists     [4]*list.List
+	bytes     [4]int64
+	cache     map[string]*list.Element // live entries in T1 and T2
+	ghosts    map[string]*list.Element // ghosts in B1 and B2
+	OnEvicted func(key string, value Value)
+}
+
+// CacheUseARC implements a segmented Adaptive Replacement Cache.
+// Each segment splits its budget between a recency list (T1) and a frequency
+// list (T2) and remembers recently evicted keys in ghost lists (B1, B2).
+// A hit on a ghost shifts the target split towards the list that would have
+// kept the key, so the cache adapts on its own between scan-heavy and
+// lookup-heavy traffic.
+type CacheUseARC struct {
+	segmented[*arcSegment]
+}
+
+// NewCacheUseARC creates a new segmented ARC cache with the specified maximum size and eviction callback.
+func NewCacheUseARC(maxBytes int64, onEvicted func(string, Value)) *CacheUseARC {
+	c := &CacheUseARC{}
+	c.init(maxBytes, func(maxBytes int64) *arcSegment {
+		seg := &arcSegment{
+			maxBytes:  maxBytes,
+			cache:     make(map[string]*list.Element),
+			ghosts:    make(map[string]*list.Element),
+			OnEvicted: onEvicted,
+		}
+		for j := range seg.lists {
+			seg.lists[j] = list.New()
+		}
+		return seg
+	}, c.CleanUp)
+	return c
+}
+
+// Get retrieves a value from the cache.
+// A hit moves the entry to the most recently used end of T2.
+func (c *CacheUseARC) Get(key string) (value Value, updateAt time.Time, ok bool) {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	if ele, ok := seg.cache[key]; ok {
+		item := seg.moveTo(ele, listT2).Value.(*arcItem)
+		item.entry.Touch()
+		return item.entry.Value, item.entry.UpdateAt, true
+	}
+	return nil, time.Time{}, false
+}
+
+// Put adds or updates a value in the cache.
+// An entry larger than its segment is not cached.
+func (c *CacheUseARC) Put(key string, value Value) {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	size := int64(len(key)) + int64(value.Len())
+	if seg.maxBytes != 0 && size > seg.maxBytes {
+		// The entry can never fit in the segment, caching it would only flush
+		// every other entry. The stale value and any ghost are dropped.
+		if ele, ok := seg.cache[key]; ok {
+			seg.remove(ele)
+		} else if ele, ok := seg.ghosts[key]; ok {
+			seg.dropGhost(ele)
+		}
+		return
+	}
+
+	if ele, ok := seg.cache[key]; ok {
+		// An update counts as a second access
+		item := ele.Value.(*arcItem)
+		seg.bytes[item.list] += size - item.size
+		item.size = size
+		item.entry.Value = value
+		item.entry.Touch()
+		seg.moveTo(ele, listT2)
+		seg.makeRoom(0, false)
+		return
+	}
+
+	entry := &Entry{Key: key, Value: value, UpdateAt: time.Now()}
+	target := listT1
+
+	if ele, ok := seg.ghosts[key]; ok {
+		// The key was evicted too early: adapt the target size of T1
+		// towards the list whose ghost was hit and admit it as frequent.
+		ghost := ele.Value.(*arcItem)
+		inB2 := ghost.list == listB2
+		if inB2 {
+			seg.p -= size * max(seg.bytes[listB1]/max(seg.bytes[listB2], 1), 1)
+			if seg.p < 0 {
+				seg.p = 0
+			}
+		} else {
+			seg.p += size * max(seg.bytes[listB2]/max(seg.bytes[listB1], 1), 1)
+			if seg.p > seg.maxBytes {
+				seg.p = seg.maxBytes
+			}
+		}
+		seg.dropGhost(ele)
+		seg.makeRoom(size, inB2)
+		target = listT2
+	} else {
+		seg.makeRoom(size, false)
+	}
+
+	item := &arcItem{entry: entry, size: size, list: target}
+	seg.cache[key] = seg.lists[target].PushBack(item)
+	seg.bytes[target] += size
+	seg.trimGhosts()
+}
+
+// Delete removes a key from the cache.
+// Like expiry, an explicit delete leaves no ghost behind.
+func (c *CacheUseARC) Delete(key string) bool {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	if ele, ok := seg.cache[key]; ok {
+		seg.remove(ele)
+		return true
+	}
+	return false
+}
+
+// CleanUp removes entries that have not been accessed within ttl.
+// Expired entries are dropped without leaving a ghost behind.
+func (c *CacheUseARC) CleanUp(ttl time.Duration) {
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		for _, l := range []int{listT1, listT2} {
+			var next *list.Element
+			for e := seg.lists[l].Front(); e != nil; e = next {
+				next = e.Next()
+				if e.Value.(*arcItem).entry.Expired(ttl) {
+					seg.remove(e)
+				}
+			}
+		}
+		seg.mu.Unlock()
+	}
+}
+
+// Len returns the total number of items in the cache.
+func (c *CacheUseARC) Len() int {
+	total := 0
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		total += len(seg.cache)
+		seg.mu.Unlock()
+	}
+	return total
+}
+
+// Entries returns a copy of the entries in the cache, from the most to the least recently used.
+func (c *CacheUseARC) Entries() []Entry {
+	var entries []Entry
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		for _, ele := range seg.cache {
+			entries = append(entries, *ele.Value.(*arcItem).entry)
+		}
+		seg.mu.Unlock()
+	}
+	sortByRecency(entries)
+	return entries
+}
+
+// moveTo moves an element to the most recently used end of the given list
+// and returns its new element.
+func (seg *arcSegment) moveTo(ele *list.Element, target int) *list.Element {
+	item := ele.Value.(*arcItem)
+	if item.list == target {
+		seg.lists[target].MoveToBack(ele)
+		return ele
+	}
+	seg.lists[item.list].Remove(ele)
+	seg.bytes[item.list] -= item.size
+	item.list = target
+	seg.bytes[target] += item.size
+
+	ele = seg.lists[target].PushBack(item)
+	if target == listT1 || target == listT2 {
+		seg.cache[item.entry.Key] = ele
+	} else {
+		seg.ghosts[item.entry.Key] = ele
+	}
+	return ele
+}
+
+// makeRoom evicts live entries into the ghost lists until an entry of the
+// given size fits. This is the REPLACE routine of ARC: T1 gives up its LRU
+// entry while it is larger than its target p, T2 otherwise.
+func (seg *arcSegment) makeRoom(size int64, hitInB2 bool) {
+	if seg.maxBytes == 0 {
+		return
+	}
+	for seg.bytes[listT1]+seg.bytes[listT2]+size > seg.maxBytes && len(seg.cache) > 0 {
+		t1 := seg.bytes[listT1]
+		from, to := listT2, listB2
+		if seg.lists[listT1].Len() > 0 && (t1 > seg.p || (hitInB2 && t1 == seg.p) || seg.lists[listT2].Len() == 0) {
+			from, to = listT1, listB1
+		}
+
+		ele := seg.lists[from].Front()
+		item := ele.Value.(*arcItem)
+		delete(seg.cache, item.entry.Key)
+		value := item.entry.Value
+		seg.moveTo(ele, to)
+		item.entry = &Entry{Key: item.entry.Key} // ghosts keep no value
+		if seg.OnEvicted != nil {
+			seg.OnEvicted(item.entry.Key, value)
+		}
+	}
+}
+
+// trimGhosts keeps T1+B1 within the segment budget and the whole directory
+// within twice the budget, dropping the oldest ghosts first.
+func (seg *arcSegment) trimGhosts() {
+	if seg.maxBytes == 0 {
+		return
+	}
+	for seg.bytes[listT1]+seg.bytes[listB1] > seg.maxBytes && seg.lists[listB1].Len() > 0 {
+		seg.dropGhost(seg.lists[listB1].Front())
+	}
+	for seg.bytes[listT1]+seg.bytes[listT2]+seg.bytes[listB1]+seg.bytes[listB2] > 2*seg.maxBytes && seg.lists[listB2].Len() > 0 {
+		seg.dropGhost(seg.lists[listB2].Front())
+	}
+}
+
+// dropGhost forgets a ghost entry.
+func (seg *arcSegment) dropGhost(ele *list.Element) {
+	item := ele.Value.(*arcItem)
+	seg.lists[item.list].Remove(ele)
+	seg.bytes[item.list] -= item.size
+	delete(seg.ghosts, item.entry.Key)
+}
+
+// remove drops a live entry without remembering it as a ghost.
+func (seg *arcSegment) remove(ele *list.Element) {
+	item := ele.Value.(*arcItem)
+	seg.lists[item.list].Remove(ele)
+	seg.bytes[item.list] -= item.size
+	delete(seg.cache, item.entry.Key)
+	if seg.OnEvicted != nil {
+		seg.OnEvicted(item.entry.Key, item.entry.Value)
+	}
+}
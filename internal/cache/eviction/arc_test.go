@@ -0,0 +1,157 @@
+package eviction
+
+import "testing"
+
+func TestCacheUseARC_Basic(t *testing.T) {
+	arc := NewCacheUseARC(1024, nil)
+	defer arc.Stop()
+
+	arc.Put("key1", String("value1"))
+	if v, _, ok := arc.Get("key1"); !ok || string(v.(String)) != "value1" {
+		t.Errorf("Get after Put failed, got %v, want %v", v, "value1")
+	}
+
+	if _, _, ok := arc.Get("missing"); ok {
+		t.Error("Get with missing key should return false")
+	}
+
+	arc.Put("key1", String("value2"))
+	if v, _, ok := arc.Get("key1"); !ok || string(v.(String)) != "value2" {
+		t.Errorf("Get after update failed, got %v, want %v", v, "value2")
+	}
+}
+
+func TestCacheUseARC_HitPromotesToT2(t *testing.T) {
+	arc := NewCacheUseARC(1024, nil)
+	defer arc.Stop()
+
+	arc.Put("k", String("v"))
+	seg := arc.getSegment("k")
+	if l := seg.cache["k"].Value.(*arcItem).list; l != listT1 {
+		t.Fatalf("new entry in list %d, want T1", l)
+	}
+
+	arc.Get("k")
+	if l := seg.cache["k"].Value.(*arcItem).list; l != listT2 {
+		t.Errorf("entry after hit in list %d, want T2", l)
+	}
+	if seg.bytes[listT1] != 0 || seg.bytes[listT2] != 2 {
+		t.Errorf("bytes T1=%d T2=%d, want 0 and 2", seg.bytes[listT1], seg.bytes[listT2])
+	}
+}
+
+func TestCacheUseARC_GhostHitAdapts(t *testing.T) {
+	var evicted []string
+	// 16 segments * 54 bytes: room for three entries of 15-17 bytes per segment
+	arc := NewCacheUseARC(864, func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	defer arc.Stop()
+
+	keys := findKeysInSameSegment(arc.getSegment, 4)
+	hot, k1, k2, k3 := keys[0], keys[1], keys[2], keys[3]
+	seg := arc.getSegment(hot)
+
+	arc.Put(hot, String("0123456789"))
+	arc.Get(hot) // hot now lives in T2
+	arc.Put(k1, String("0123456789"))
+	arc.Put(k2, String("0123456789"))
+	arc.Put(k3, String("0123456789"))
+
+	if len(evicted) != 1 || evicted[0] != k1 {
+		t.Fatalf("evicted = %v, want [%s]", evicted, k1)
+	}
+	if ele, ok := seg.ghosts[k1]; !ok || ele.Value.(*arcItem).list != listB1 {
+		t.Fatalf("evicted key %s should be a ghost in B1", k1)
+	}
+
+	// Loading the key again is a ghost hit: T1 should have been larger
+	arc.Put(k1, String("0123456789"))
+	if seg.p == 0 {
+		t.Error("ghost hit in B1 should increase the target size of T1")
+	}
+	if l := seg.cache[k1].Value.(*arcItem).list; l != listT2 {
+		t.Errorf("key readmitted from ghost in list %d, want T2", l)
+	}
+	if _, ok := seg.ghosts[k1]; ok {
+		t.Error("readmitted key should no longer be a ghost")
+	}
+}
+
+func TestCacheUseARC_ScanResistance(t *testing.T) {
+	// 16 segments * 100 bytes: roughly ten entries per segment
+	arc := NewCacheUseARC(1600, nil)
+	defer arc.Stop()
+
+	keys := findKeysInSameSegment(arc.getSegment, 33)
+	hot, scan := keys[:3], keys[3:]
+
+	for _, k := range hot {
+		arc.Put(k, String("1234"))
+		arc.Get(k)
+	}
+	for _, k := range scan {
+		arc.Put(k, String("1234"))
+	}
+
+	for _, k := range hot {
+		if _, _, ok := arc.Get(k); !ok {
+			t.Errorf("frequently used key %s should survive a scan", k)
+		}
+	}
+
+	seg := arc.getSegment(hot[0])
+	if live := seg.bytes[listT1] + seg.bytes[listT2]; live > seg.maxBytes {
+		t.Errorf("live bytes %d exceed segment budget %d", live, seg.maxBytes)
+	}
+	if all := directoryBytes(seg); all > 2*seg.maxBytes {
+		t.Errorf("directory bytes %d exceed twice the segment budget %d", all, seg.maxBytes)
+	}
+}
+
+func TestCacheUseARC_OversizedEntry(t *testing.T) {
+	var evicted []string
+	// 16 segments * 54 bytes
+	arc := NewCacheUseARC(864, func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	defer arc.Stop()
+
+	keys := findKeysInSameSegment(arc.getSegment, 2)
+	small, big := keys[0], keys[1]
+	seg := arc.getSegment(small)
+	oversized := String(make([]byte, seg.maxBytes))
+
+	arc.Put(small, String("0123456789"))
+	arc.Put(big, oversized)
+	if _, _, ok := arc.Get(big); ok {
+		t.Error("entry larger than the segment should not be cached")
+	}
+	if _, _, ok := arc.Get(small); !ok || len(evicted) != 0 {
+		t.Errorf("oversized entry evicted %v, want nothing", evicted)
+	}
+
+	// Growing a cached entry beyond the segment drops it
+	arc.Put(small, oversized)
+	if _, _, ok := arc.Get(small); ok {
+		t.Error("entry grown beyond the segment should be dropped")
+	}
+	if live := seg.bytes[listT1] + seg.bytes[listT2]; live != 0 || arc.Len() != 0 {
+		t.Errorf("live bytes = %d, Len() = %d, want an empty cache", live, arc.Len())
+	}
+}
+
+func TestNew_ARC(t *testing.T) {
+	s, err := New("arc", 1024, nil)
+	if err != nil {
+		t.Fatalf("New(arc) returned error: %v", err)
+	}
+	if _, ok := s.(*CacheUseARC); !ok {
+		t.Errorf("New(arc) returned %T, want *CacheUseARC", s)
+	}
+}
+
+// directoryBytes returns the bytes tracked by all four lists of a segment
+func directoryBytes(seg *arcSegment) int64 {
+	return seg.bytes[listT1] + seg.bytes[listT2] + seg.bytes[listB1] + seg.bytes[listB2]
+}
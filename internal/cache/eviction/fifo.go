@@ -0,0 +1,271 @@
+package eviction
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	smallQueueRatio = 10 // Percentage of a segment's bytes reserved for the small queue
+	maxFIFOFreq     = 3  // Saturation point of the per-entry access counter
+)
+
+// fifoEntry is a cache entry with the access counter used by S3-FIFO.
+// freq is only ever changed atomically so Get can run under a read lock.
+type fifoEntry struct {
+	entry *Entry
+	freq  atomic.Int32
+	main  bool // whether the entry lives in the main queue
+}
+
+// fifoSegment represents a portion of the FIFO cache with its own lock.
+type fifoSegment struct {
+	mu         sync.RWMutex
+	maxBytes   int64
+	nbytes     int64
+	smallBytes int64
+	small      *list.List // probationary queue for newly inserted keys
+	main       *list.List // queue for keys that were accessed again
+	ghost      *list.List // keys recently evicted from the small queue
+	ghostKeys  map[string]*list.Element
+	cache      map[string]*list.Element
+	OnEvicted  func(key string, value Value)
+}
+
+// CacheUseFIFO implements a segmented S3-FIFO cache.
+// Entries are only ever appended to queues, Get never reorders anything and
+// therefore only takes a read lock. A small probationary queue filters out
+// keys that are read once, a ghost queue remembers them briefly so that a
+// quick re-insert goes straight to the main queue.
+// Since reads do not touch entries, TTL is measured from the last Put.
+type CacheUseFIFO struct {
+	segmented[*fifoSegment]
+}
+
+// NewCacheUseFIFO creates a new segmented S3-FIFO cache with the specified maximum size and eviction callback.
+func NewCacheUseFIFO(maxBytes int64, onEvicted func(string, Value)) *CacheUseFIFO {
+	c := &CacheUseFIFO{}
+	c.init(maxBytes, func(maxBytes int64) *fifoSegment {
+		return &fifoSegment{
+			maxBytes:  maxBytes,
+			small:     list.New(),
+			main:      list.New(),
+			ghost:     list.New(),
+			ghostKeys: make(map[string]*list.Element),
+			cache:     make(map[string]*list.Element),
+			OnEvicted: onEvicted,
+		}
+	}, c.CleanUp)
+	return c
+}
+
+// Get retrieves a value from the cache.
+// It only records the access in the entry's counter and never moves the entry.
+func (c *CacheUseFIFO) Get(key string) (value Value, updateAt time.Time, ok bool) {
+	seg := c.getSegment(key)
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+
+	if ele, ok := seg.cache[key]; ok {
+		e := ele.Value.(*fifoEntry)
+		e.hit()
+		return e.entry.Value, e.entry.UpdateAt, true
+	}
+	return nil, time.Time{}, false
+}
+
+// Put adds or updates a value in the cache.
+func (c *CacheUseFIFO) Put(key string, value Value) {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	newBytes := int64(len(key)) + int64(value.Len())
+
+	if ele, ok := seg.cache[key]; ok {
+		e := ele.Value.(*fifoEntry)
+		oldBytes := int64(len(e.entry.Key)) + int64(e.entry.Value.Len())
+		e.entry.Value = value
+		e.entry.Touch()
+		e.hit()
+		seg.nbytes = seg.nbytes - oldBytes + newBytes
+		if !e.main {
+			seg.smallBytes = seg.smallBytes - oldBytes + newBytes
+		}
+	} else {
+		e := &fifoEntry{
+			entry: &Entry{
+				Key:      key,
+				Value:    value,
+				UpdateAt: time.Now(),
+			},
+		}
+		if g, ok := seg.ghostKeys[key]; ok {
+			// Evicted from the small queue a moment ago, it deserves the main queue.
+			seg.ghost.Remove(g)
+			delete(seg.ghostKeys, key)
+			e.main = true
+			seg.cache[key] = seg.main.PushBack(e)
+		} else {
+			seg.cache[key] = seg.small.PushBack(e)
+			seg.smallBytes += newBytes
+		}
+		seg.nbytes += newBytes
+	}
+
+	// Keep evicting until we're under maxBytes
+	for seg.maxBytes != 0 && seg.maxBytes < seg.nbytes && len(seg.cache) > 0 {
+		seg.evict()
+	}
+}
+
+// Delete removes a key from the cache.
+// The key is not remembered as a ghost, a later Put starts in the small queue.
+func (c *CacheUseFIFO) Delete(key string) bool {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	if ele, ok := seg.cache[key]; ok {
+		seg.removeElement(ele)
+		return true
+	}
+	return false
+}
+
+// CleanUp removes entries that were last written more than ttl ago.
+func (c *CacheUseFIFO) CleanUp(ttl time.Duration) {
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		for _, l := range []*list.List{seg.small, seg.main} {
+			var next *list.Element
+			for e := l.Front(); e != nil; e = next {
+				next = e.Next()
+				if e.Value.(*fifoEntry).entry.Expired(ttl) {
+					seg.removeElement(e)
+				}
+			}
+		}
+		seg.mu.Unlock()
+	}
+}
+
+// Len returns the total number of items in the cache.
+func (c *CacheUseFIFO) Len() int {
+	total := 0
+	for _, seg := range c.segments {
+		seg.mu.RLock()
+		total += len(seg.cache)
+		seg.mu.RUnlock()
+	}
+	return total
+}
+
+// Entries returns a copy of the entries in the cache, from the most to the least recently written.
+// Get only counts accesses without recording their time, so reads do not reorder them.
+func (c *CacheUseFIFO) Entries() []Entry {
+	var entries []Entry
+	for _, seg := range c.segments {
+		seg.mu.RLock()
+		for _, ele := range seg.cache {
+			entries = append(entries, *ele.Value.(*fifoEntry).entry)
+		}
+		seg.mu.RUnlock()
+	}
+	sortByRecency(entries)
+	return entries
+}
+
+// hit records an access, saturating at maxFIFOFreq.
+func (e *fifoEntry) hit() {
+	for {
+		f := e.freq.Load()
+		if f >= maxFIFOFreq || e.freq.CompareAndSwap(f, f+1) {
+			return
+		}
+	}
+}
+
+// evict removes exactly one entry, taking it from the small queue while that
+// queue is over its share of the segment, and from the main queue otherwise.
+func (seg *fifoSegment) evict() {
+	for {
+		if seg.small.Len() > 0 && (seg.smallBytes*100 > seg.maxBytes*smallQueueRatio || seg.main.Len() == 0) {
+			if seg.evictSmall() {
+				return
+			}
+			continue
+		}
+		if seg.main.Len() == 0 {
+			return
+		}
+		if seg.evictMain() {
+			return
+		}
+	}
+}
+
+// evictSmall pops the head of the small queue. Entries read since insertion
+// are promoted to the main queue, the others are evicted and remembered as ghosts.
+// It reports whether an entry was evicted.
+func (seg *fifoSegment) evictSmall() bool {
+	ele := seg.small.Front()
+	e := ele.Value.(*fifoEntry)
+	size := int64(len(e.entry.Key)) + int64(e.entry.Value.Len())
+
+	if e.freq.Load() > 0 {
+		seg.small.Remove(ele)
+		seg.smallBytes -= size
+		e.freq.Store(0)
+		e.main = true
+		seg.cache[e.entry.Key] = seg.main.PushBack(e)
+		return false
+	}
+
+	seg.removeElement(ele)
+	seg.addGhost(e.entry.Key)
+	return true
+}
+
+// evictMain pops the head of the main queue, giving entries with remaining
+// access credit another round at the tail. It reports whether an entry was evicted.
+func (seg *fifoSegment) evictMain() bool {
+	ele := seg.main.Front()
+	e := ele.Value.(*fifoEntry)
+	if f := e.freq.Load(); f > 0 {
+		e.freq.Store(f - 1)
+		seg.main.MoveToBack(ele)
+		return false
+	}
+	seg.removeElement(ele)
+	return true
+}
+
+// addGhost remembers an evicted key, keeping at most as many ghosts as live entries.
+func (seg *fifoSegment) addGhost(key string) {
+	seg.ghostKeys[key] = seg.ghost.PushBack(key)
+	for seg.ghost.Len() > len(seg.cache)+1 {
+		oldest := seg.ghost.Front()
+		seg.ghost.Remove(oldest)
+		delete(seg.ghostKeys, oldest.Value.(string))
+	}
+}
+
+// removeElement removes an element from whichever queue it lives in.
+func (seg *fifoSegment) removeElement(ele *list.Element) {
+	e := ele.Value.(*fifoEntry)
+	size := int64(len(e.entry.Key)) + int64(e.entry.Value.Len())
+	if e.main {
+		seg.main.Remove(ele)
+	} else {
+		seg.small.Remove(ele)
+		seg.smallBytes -= size
+	}
+	delete(seg.cache, e.entry.Key)
+	seg.nbytes -= size
+	if seg.OnEvicted != nil {
+		seg.OnEvicted(e.entry.Key, e.entry.Value)
+	}
+}
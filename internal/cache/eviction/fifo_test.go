@@ -0,0 +1,153 @@
+package eviction
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheUseFIFO_Basic(t *testing.T) {
+	fifo := NewCacheUseFIFO(1024, nil)
+	defer fifo.Stop()
+
+	fifo.Put("key1", String("value1"))
+	if v, _, ok := fifo.Get("key1"); !ok || string(v.(String)) != "value1" {
+		t.Errorf("Get after Put failed, got %v, want %v", v, "value1")
+	}
+
+	if _, _, ok := fifo.Get("missing"); ok {
+		t.Error("Get with missing key should return false")
+	}
+
+	fifo.Put("key1", String("value2"))
+	if v, _, ok := fifo.Get("key1"); !ok || string(v.(String)) != "value2" {
+		t.Errorf("Get after update failed, got %v, want %v", v, "value2")
+	}
+}
+
+func TestCacheUseFIFO_InsertionOrder(t *testing.T) {
+	var evicted []string
+	// 16 segments * 24 bytes: room for two entries of ~9 bytes per segment
+	fifo := NewCacheUseFIFO(384, func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	defer fifo.Stop()
+
+	keys := findKeysInSameSegment(fifo.getSegment, 4)
+	for _, k := range keys {
+		fifo.Put(k, String("1234"))
+	}
+
+	want := keys[:2]
+	if len(evicted) != len(want) || evicted[0] != want[0] || evicted[1] != want[1] {
+		t.Errorf("evicted = %v, want %v", evicted, want)
+	}
+}
+
+func TestCacheUseFIFO_AccessedKeySurvivesScan(t *testing.T) {
+	fifo := NewCacheUseFIFO(384, nil)
+	defer fifo.Stop()
+
+	keys := findKeysInSameSegment(fifo.getSegment, 6)
+	hot := keys[0]
+	fifo.Put(hot, String("1234"))
+	fifo.Get(hot)
+
+	// A scan of keys that are never read again must not push out the hot key
+	for _, k := range keys[1:] {
+		fifo.Put(k, String("1234"))
+		fifo.Get(hot)
+	}
+
+	if _, _, ok := fifo.Get(hot); !ok {
+		t.Error("accessed key should have been promoted and kept")
+	}
+}
+
+func TestCacheUseFIFO_GhostReadmission(t *testing.T) {
+	fifo := NewCacheUseFIFO(384, nil)
+	defer fifo.Stop()
+
+	keys := findKeysInSameSegment(fifo.getSegment, 3)
+	fifo.Put(keys[0], String("1234"))
+	fifo.Put(keys[1], String("1234"))
+	fifo.Put(keys[2], String("1234")) // evicts keys[0] into the ghost queue
+
+	fifo.Put(keys[0], String("1234"))
+
+	seg := fifo.getSegment(keys[0])
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	ele, ok := seg.cache[keys[0]]
+	if !ok {
+		t.Fatal("re-inserted key should be cached")
+	}
+	if !ele.Value.(*fifoEntry).main {
+		t.Error("key found in the ghost queue should be admitted to the main queue")
+	}
+}
+
+func TestCacheUseFIFO_CleanUp(t *testing.T) {
+	fifo := NewCacheUseFIFO(1024, nil)
+	defer fifo.Stop()
+
+	fifo.Put("k1", String("v1"))
+	time.Sleep(20 * time.Millisecond)
+
+	// Reads do not refresh the TTL of a FIFO entry
+	fifo.Get("k1")
+	fifo.CleanUp(10 * time.Millisecond)
+
+	if fifo.Len() != 0 {
+		t.Errorf("CleanUp failed, cache should be empty, got len = %d", fifo.Len())
+	}
+}
+
+func TestCacheUseFIFO_ZeroSize(t *testing.T) {
+	fifo := NewCacheUseFIFO(0, nil) // Zero size means unlimited
+	defer fifo.Stop()
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i)
+		fifo.Put(key, String(key))
+	}
+
+	if fifo.Len() != 100 {
+		t.Errorf("Expected 100 entries in unlimited cache, got %d", fifo.Len())
+	}
+}
+
+func TestCacheUseFIFO_Concurrent(t *testing.T) {
+	fifo := NewCacheUseFIFO(1024, nil)
+	defer fifo.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := fmt.Sprintf("k%d", j%100)
+				fifo.Put(key, String(key))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				fifo.Get(fmt.Sprintf("k%d", j%100))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNew_FIFO(t *testing.T) {
+	s, err := New("fifo", 1024, nil)
+	if err != nil {
+		t.Fatalf("New(fifo) returned error: %v", err)
+	}
+	if _, ok := s.(*CacheUseFIFO); !ok {
+		t.Errorf("New(fifo) returned %T, want *CacheUseFIFO", s)
+	}
+}
@@ -0,0 +1,268 @@
+package eviction
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAgingFactor  = 10 // Halve all frequencies after agingFactor*len(cache) accesses
+	minAgingSampleCount = 64 // Lower bound of accesses between two aging passes
+)
+
+// lfuItem is a cache entry together with its access frequency.
+type lfuItem struct {
+	entry *Entry
+	freq  int
+	ele   *list.Element // element inside the frequency bucket
+}
+
+// lfuSegment represents a portion of the LFU cache with its own lock.
+// Items with the same frequency are kept in one bucket, ordered from the
+// oldest to the most recently touched, so every operation is O(1).
+type lfuSegment struct {
+	mu        sync.Mutex
+	maxBytes  int64
+	nbytes    int64
+	cache     map[string]*lfuItem
+	buckets   map[int]*list.List // frequency -> items with that frequency
+	minFreq   int
+	samples   int // accesses since the last aging pass
+	OnEvicted func(key string, value Value)
+}
+
+// CacheUseLFU implements a segmented Least Frequently Used (LFU) cache.
+// Access counts are periodically halved so that keys that were hot a long
+// time ago do not stay in the cache forever.
+type CacheUseLFU struct {
+	segmented[*lfuSegment]
+}
+
+// NewCacheUseLFU creates a new segmented LFU cache with the specified maximum size and eviction callback.
+func NewCacheUseLFU(maxBytes int64, onEvicted func(string, Value)) *CacheUseLFU {
+	c := &CacheUseLFU{}
+	c.init(maxBytes, func(maxBytes int64) *lfuSegment {
+		return &lfuSegment{
+			maxBytes:  maxBytes,
+			cache:     make(map[string]*lfuItem),
+			buckets:   make(map[int]*list.List),
+			OnEvicted: onEvicted,
+		}
+	}, c.CleanUp)
+	return c
+}
+
+// Get retrieves a value from the cache and bumps its access frequency.
+func (c *CacheUseLFU) Get(key string) (value Value, updateAt time.Time, ok bool) {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	if item, ok := seg.cache[key]; ok {
+		seg.increment(item)
+		item.entry.Touch()
+		return item.entry.Value, item.entry.UpdateAt, true
+	}
+	return nil, time.Time{}, false
+}
+
+// Put adds or updates a value in the cache.
+// New entries start with a frequency of one; updates count as an access.
+func (c *CacheUseLFU) Put(key string, value Value) {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	newBytes := int64(len(key)) + int64(value.Len())
+
+	if item, ok := seg.cache[key]; ok {
+		oldBytes := int64(len(item.entry.Key)) + int64(item.entry.Value.Len())
+		item.entry.Value = value
+		item.entry.Touch()
+		seg.nbytes = seg.nbytes - oldBytes + newBytes
+		seg.increment(item)
+	} else {
+		// Make room before inserting so the new entry is not its own victim.
+		for seg.maxBytes != 0 && len(seg.cache) > 0 && seg.maxBytes < seg.nbytes+newBytes {
+			seg.removeLeastFrequent()
+		}
+		item := &lfuItem{
+			entry: &Entry{
+				Key:      key,
+				Value:    value,
+				UpdateAt: time.Now(),
+			},
+			freq: 1,
+		}
+		item.ele = seg.bucket(1).PushBack(item)
+		seg.cache[key] = item
+		seg.nbytes += newBytes
+		seg.minFreq = 1
+	}
+
+	// Keep removing least frequently used entries until we're under maxBytes
+	for seg.maxBytes != 0 && seg.maxBytes < seg.nbytes {
+		seg.removeLeastFrequent()
+	}
+}
+
+// Delete removes a key from the cache.
+func (c *CacheUseLFU) Delete(key string) bool {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	if item, ok := seg.cache[key]; ok {
+		seg.removeItem(item)
+		return true
+	}
+	return false
+}
+
+// CleanUp removes entries that have not been accessed within ttl.
+func (c *CacheUseLFU) CleanUp(ttl time.Duration) {
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		for _, item := range seg.cache {
+			if item.entry.Expired(ttl) {
+				seg.removeItem(item)
+			}
+		}
+		seg.mu.Unlock()
+	}
+}
+
+// Len returns the total number of items in the cache.
+func (c *CacheUseLFU) Len() int {
+	total := 0
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		total += len(seg.cache)
+		seg.mu.Unlock()
+	}
+	return total
+}
+
+// Entries returns a copy of the entries in the cache, from the most to the least recently used.
+func (c *CacheUseLFU) Entries() []Entry {
+	var entries []Entry
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		for _, item := range seg.cache {
+			entries = append(entries, *item.entry)
+		}
+		seg.mu.Unlock()
+	}
+	sortByRecency(entries)
+	return entries
+}
+
+// bucket returns the list holding items of the given frequency, creating it if needed.
+func (seg *lfuSegment) bucket(freq int) *list.List {
+	l, ok := seg.buckets[freq]
+	if !ok {
+		l = list.New()
+		seg.buckets[freq] = l
+	}
+	return l
+}
+
+// increment moves an item into the next frequency bucket and ages the
+// segment once enough accesses have been observed.
+func (seg *lfuSegment) increment(item *lfuItem) {
+	seg.unlink(item)
+	if item.freq == seg.minFreq && seg.buckets[item.freq] == nil {
+		seg.minFreq = item.freq + 1
+	}
+	item.freq++
+	item.ele = seg.bucket(item.freq).PushBack(item)
+
+	seg.samples++
+	if seg.samples >= seg.agingThreshold() {
+		seg.age()
+	}
+}
+
+// agingThreshold returns how many accesses trigger the next aging pass.
+func (seg *lfuSegment) agingThreshold() int {
+	if n := defaultAgingFactor * len(seg.cache); n > minAgingSampleCount {
+		return n
+	}
+	return minAgingSampleCount
+}
+
+// age halves the frequency of every item so old popularity decays.
+// Relative order inside a bucket is preserved.
+func (seg *lfuSegment) age() {
+	old := seg.buckets
+	seg.buckets = make(map[int]*list.List, len(old))
+	seg.samples = 0
+	seg.minFreq = 0
+
+	freqs := make([]int, 0, len(old))
+	for f := range old {
+		freqs = append(freqs, f)
+	}
+	sort.Ints(freqs)
+
+	for _, f := range freqs {
+		for e := old[f].Front(); e != nil; e = e.Next() {
+			item := e.Value.(*lfuItem)
+			item.freq = f / 2
+			if item.freq < 1 {
+				item.freq = 1
+			}
+			item.ele = seg.bucket(item.freq).PushBack(item)
+			if seg.minFreq == 0 || item.freq < seg.minFreq {
+				seg.minFreq = item.freq
+			}
+		}
+	}
+}
+
+// unlink removes an item from its frequency bucket, dropping empty buckets.
+func (seg *lfuSegment) unlink(item *lfuItem) {
+	l := seg.buckets[item.freq]
+	l.Remove(item.ele)
+	if l.Len() == 0 {
+		delete(seg.buckets, item.freq)
+	}
+}
+
+// removeLeastFrequent evicts the oldest item of the lowest frequency.
+// minFreq is kept up to date on inserts and promotions, it is only recomputed
+// here once removals emptied its bucket.
+func (seg *lfuSegment) removeLeastFrequent() {
+	l, ok := seg.buckets[seg.minFreq]
+	if !ok {
+		seg.resetMinFreq()
+		if l, ok = seg.buckets[seg.minFreq]; !ok {
+			return
+		}
+	}
+	seg.removeItem(l.Front().Value.(*lfuItem))
+}
+
+// removeItem removes an item from a segment.
+// It may leave minFreq pointing at an empty bucket, see removeLeastFrequent.
+func (seg *lfuSegment) removeItem(item *lfuItem) {
+	seg.unlink(item)
+	entry := item.entry
+	delete(seg.cache, entry.Key)
+	seg.nbytes -= int64(len(entry.Key)) + int64(entry.Value.Len())
+	if seg.OnEvicted != nil {
+		seg.OnEvicted(entry.Key, entry.Value)
+	}
+}
+
+// resetMinFreq recomputes the lowest non-empty frequency.
+func (seg *lfuSegment) resetMinFreq() {
+	seg.minFreq = 0
+	for f := range seg.buckets {
+		if seg.minFreq == 0 || f < seg.minFreq {
+			seg.minFreq = f
+		}
+	}
+}
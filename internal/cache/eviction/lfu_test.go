@@ -0,0 +1,226 @@
+package eviction
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheUseLFU_Basic(t *testing.T) {
+	lfu := NewCacheUseLFU(1024, nil)
+	defer lfu.Stop()
+
+	lfu.Put("key1", String("value1"))
+	if v, _, ok := lfu.Get("key1"); !ok || string(v.(String)) != "value1" {
+		t.Errorf("Get after Put failed, got %v, want %v", v, "value1")
+	}
+
+	if _, _, ok := lfu.Get("missing"); ok {
+		t.Error("Get with missing key should return false")
+	}
+
+	lfu.Put("key1", String("value2"))
+	if v, _, ok := lfu.Get("key1"); !ok || string(v.(String)) != "value2" {
+		t.Errorf("Get after update failed, got %v, want %v", v, "value2")
+	}
+	if lfu.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", lfu.Len())
+	}
+}
+
+func TestCacheUseLFU_EvictsLeastFrequent(t *testing.T) {
+	var mu sync.Mutex
+	var evicted []string
+	// 16 segments * 24 bytes: room for two entries of ~9 bytes per segment
+	lfu := NewCacheUseLFU(384, func(key string, value Value) {
+		mu.Lock()
+		evicted = append(evicted, key)
+		mu.Unlock()
+	})
+	defer lfu.Stop()
+
+	keys := findKeysInSameSegment(lfu.getSegment, 3)
+	k1, k2, k3 := keys[0], keys[1], keys[2]
+
+	lfu.Put(k1, String("1234"))
+	lfu.Put(k2, String("5678"))
+
+	// k1 is hot, k2 is only touched once more
+	for i := 0; i < 5; i++ {
+		lfu.Get(k1)
+	}
+	lfu.Get(k2)
+
+	// k3 needs space: k2 is the least frequently used entry
+	lfu.Put(k3, String("9012"))
+
+	if _, _, ok := lfu.Get(k1); !ok {
+		t.Error("hot key k1 should still be cached")
+	}
+	if _, _, ok := lfu.Get(k2); ok {
+		t.Error("k2 should have been evicted")
+	}
+	if _, _, ok := lfu.Get(k3); !ok {
+		t.Error("new key k3 should be cached")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(evicted) != 1 || evicted[0] != k2 {
+		t.Errorf("evicted = %v, want [%s]", evicted, k2)
+	}
+}
+
+func TestCacheUseLFU_SameFrequencyEvictsOldest(t *testing.T) {
+	lfu := NewCacheUseLFU(384, nil)
+	defer lfu.Stop()
+
+	keys := findKeysInSameSegment(lfu.getSegment, 3)
+	lfu.Put(keys[0], String("1234"))
+	lfu.Put(keys[1], String("5678"))
+	lfu.Put(keys[2], String("9012"))
+
+	if _, _, ok := lfu.Get(keys[0]); ok {
+		t.Error("oldest key with the lowest frequency should have been evicted")
+	}
+	for _, k := range keys[1:] {
+		if _, _, ok := lfu.Get(k); !ok {
+			t.Errorf("key %s should be cached", k)
+		}
+	}
+}
+
+func TestCacheUseLFU_EvictsAfterDeletingLeastFrequent(t *testing.T) {
+	// 16 segments * 40 bytes
+	lfu := NewCacheUseLFU(640, nil)
+	defer lfu.Stop()
+
+	keys := findKeysInSameSegment(lfu.getSegment, 3)
+	for i, k := range keys {
+		lfu.Put(k, String("1234"))
+		for j := 0; j <= i; j++ {
+			lfu.Get(k)
+		}
+	}
+
+	// Deleting the only entry of the lowest frequency empties its bucket,
+	// growing keys[2] must then evict keys[1], now the least frequent
+	lfu.Delete(keys[0])
+	lfu.Put(keys[2], String("123456789012345678901234567890"))
+
+	if _, _, ok := lfu.Get(keys[1]); ok {
+		t.Errorf("key %s should have been evicted", keys[1])
+	}
+	if _, _, ok := lfu.Get(keys[2]); !ok {
+		t.Errorf("key %s should be cached", keys[2])
+	}
+}
+
+func TestCacheUseLFU_Aging(t *testing.T) {
+	lfu := NewCacheUseLFU(0, nil)
+	defer lfu.Stop()
+
+	lfu.Put("k", String("v"))
+	seg := lfu.getSegment("k")
+
+	for i := 0; i < minAgingSampleCount-1; i++ {
+		lfu.Get("k")
+	}
+	seg.mu.Lock()
+	before := seg.cache["k"].freq
+	seg.mu.Unlock()
+	if before != minAgingSampleCount {
+		t.Fatalf("freq before aging = %d, want %d", before, minAgingSampleCount)
+	}
+
+	// The next access crosses the aging threshold and halves the count
+	lfu.Get("k")
+	seg.mu.Lock()
+	after := seg.cache["k"].freq
+	seg.mu.Unlock()
+	if after != (minAgingSampleCount+1)/2 {
+		t.Errorf("freq after aging = %d, want %d", after, (minAgingSampleCount+1)/2)
+	}
+}
+
+func TestCacheUseLFU_CleanUp(t *testing.T) {
+	lfu := NewCacheUseLFU(1024, nil)
+	defer lfu.Stop()
+
+	lfu.Put("k1", String("v1"))
+	lfu.Put("k2", String("v2"))
+
+	time.Sleep(20 * time.Millisecond)
+	lfu.Get("k2")
+	lfu.CleanUp(10 * time.Millisecond)
+
+	if _, _, ok := lfu.Get("k1"); ok {
+		t.Error("k1 should have expired")
+	}
+	if _, _, ok := lfu.Get("k2"); !ok {
+		t.Error("recently accessed k2 should still be cached")
+	}
+}
+
+func TestCacheUseLFU_SetCleanupInterval(t *testing.T) {
+	lfu := NewCacheUseLFU(1024, nil)
+	lfu.SetTTL(5 * time.Millisecond)
+	lfu.SetCleanupInterval(10 * time.Millisecond)
+	defer lfu.Stop()
+
+	lfu.Put("key1", String("value1"))
+	time.Sleep(30 * time.Millisecond)
+
+	if lfu.Len() != 0 {
+		t.Errorf("Entry should have been automatically cleaned up, got len = %d", lfu.Len())
+	}
+}
+
+func TestCacheUseLFU_ZeroSize(t *testing.T) {
+	lfu := NewCacheUseLFU(0, nil) // Zero size means unlimited
+	defer lfu.Stop()
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i)
+		lfu.Put(key, String(key))
+	}
+
+	if lfu.Len() != 100 {
+		t.Errorf("Expected 100 entries in unlimited cache, got %d", lfu.Len())
+	}
+}
+
+func TestCacheUseLFU_Concurrent(t *testing.T) {
+	lfu := NewCacheUseLFU(1024, nil)
+	defer lfu.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := fmt.Sprintf("k%d", j%100)
+				lfu.Put(key, String(key))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				lfu.Get(fmt.Sprintf("k%d", j%100))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNew_LFU(t *testing.T) {
+	s, err := New("lfu", 1024, nil)
+	if err != nil {
+		t.Fatalf("New(lfu) returned error: %v", err)
+	}
+	if _, ok := s.(*CacheUseLFU); !ok {
+		t.Errorf("New(lfu) returned %T, want *CacheUseLFU", s)
+	}
+}
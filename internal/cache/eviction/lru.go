@@ -2,7 +2,6 @@ package eviction
 
 import (
 	"container/list"
-	"hash/fnv"
 	"sync"
 	"time"
 	"distcache/pkg/common/logger"
@@ -29,95 +28,23 @@ type segment struct {
 // CacheUseLRU implements a segmented Least Recently Used (LRU) cache.
 // It maintains multiple segments, each with its own lock, to reduce lock contention.
 type CacheUseLRU struct {
-	segments        []*segment
-	numSegments     int
-	cleanupInterval time.Duration
-	ttl             time.Duration
-	stopCleanup     chan struct{}
-	mu              sync.RWMutex
+	segmented[*segment]
 }
 
 // NewCacheUseLRU creates a new segmented LRU cache with the specified maximum size and eviction callback.
 func NewCacheUseLRU(maxBytes int64, onEvicted func(string, Value)) *CacheUseLRU {
-	c := &CacheUseLRU{
-		segments:        make([]*segment, defaultNumSegments),
-		numSegments:     defaultNumSegments,
-		cleanupInterval: defaultCleanupInterval,
-		ttl:             defaultTTL,
-		stopCleanup:     make(chan struct{}),
-	}
-
-	// Initialize segments
-	segmentMaxBytes := maxBytes / int64(defaultNumSegments)
-	for i := 0; i < defaultNumSegments; i++ {
-		c.segments[i] = &segment{
-			maxBytes:  segmentMaxBytes,
+	c := &CacheUseLRU{}
+	c.init(maxBytes, func(maxBytes int64) *segment {
+		return &segment{
+			maxBytes:  maxBytes,
 			ll:        list.New(),
 			cache:     make(map[string]*list.Element),
 			OnEvicted: onEvicted,
 		}
-	}
-
-	// Start cleanup routine
-	go c.cleanupRoutine()
-
+	}, c.CleanUp)
 	return c
 }
 
-// getSegment returns the appropriate segment for a given key.
-// Use the FNV hash algorithm to determine which segment the key belongs to.
-func (c *CacheUseLRU) getSegment(key string) *segment {
-	h := fnv.New32a()
-	h.Write([]byte(key))
-	return c.segments[h.Sum32()%uint32(c.numSegments)]
-}
-
-// SetTTL sets the time-to-live for cache entries.
-func (c *CacheUseLRU) SetTTL(ttl time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.ttl = ttl
-}
-
-// SetCleanupInterval sets the interval between cleanup runs.
-func (c *CacheUseLRU) SetCleanupInterval(interval time.Duration) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	// Stop existing cleanup routine
-	close(c.stopCleanup)
-
-	// Create new stop channel and set new interval
-	c.stopCleanup = make(chan struct{})
-	c.cleanupInterval = interval
-
-	// Start new cleanup routine
-	go c.cleanupRoutine()
-}
-
-// Stop stops the cleanup routine.
-func (c *CacheUseLRU) Stop() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	close(c.stopCleanup)
-}
-
-// cleanupRoutine periodically cleans up expired entries across all segments.
-func (c *CacheUseLRU) cleanupRoutine() {
-	ticker := time.NewTicker(c.cleanupInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			currentTTL := c.ttl // capture current TTL value
-			loggerInstance.Infof("cleanupRoutine triggered after %v", c.cleanupInterval)
-			c.CleanUp(currentTTL)
-		case <-c.stopCleanup:
-			return
-		}
-	}
-}
-
 // cleanupSegment removes expired entries from a single segment
 func (c *CacheUseLRU) cleanupSegment(seg *segment, ttl time.Duration) {
 	seg.mu.Lock()
@@ -136,10 +63,11 @@ func (c *CacheUseLRU) cleanupSegment(seg *segment, ttl time.Duration) {
 }
 
 // Get retrieves a value from the cache.
+// It moves the entry to the back of the list, so it needs the write lock.
 func (c *CacheUseLRU) Get(key string) (value Value, updateAt time.Time, ok bool) {
 	seg := c.getSegment(key)
-	seg.mu.RLock()
-	defer seg.mu.RUnlock()
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
 
 	if ele, ok := seg.cache[key]; ok {
 		seg.ll.MoveToBack(ele)
@@ -182,6 +110,19 @@ func (c *CacheUseLRU) Put(key string, value Value) {
 	}
 }
 
+// Delete removes a key from the cache.
+func (c *CacheUseLRU) Delete(key string) bool {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	if ele, ok := seg.cache[key]; ok {
+		seg.removeElement(ele)
+		return true
+	}
+	return false
+}
+
 func (c *CacheUseLRU) CleanUp(ttl time.Duration) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -221,3 +162,17 @@ func (c *CacheUseLRU) Len() int {
 	}
 	return total
 }
+
+// Entries returns a copy of the entries in the cache, from the most to the least recently used.
+func (c *CacheUseLRU) Entries() []Entry {
+	var entries []Entry
+	for _, seg := range c.segments {
+		seg.mu.RLock()
+		for _, ele := range seg.cache {
+			entries = append(entries, *ele.Value.(*Entry))
+		}
+		seg.mu.RUnlock()
+	}
+	sortByRecency(entries)
+	return entries
+}
@@ -1,12 +1,18 @@
 package eviction
 
 import (
-	"fmt"
 	"sync"
 	"testing"
 	"time"
 )
 
+// String is a Value implementation used by the tests.
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
 func TestCacheUseLRU_Basic(t *testing.T) {
 	t.Run("creation", func(t *testing.T) {
 		lru := NewCacheUseLRU(100, nil)
@@ -126,7 +132,7 @@ func TestCacheUseLRU_MemoryManagement(t *testing.T) {
 	defer lru.Stop()
 
 	// Find 3 keys that hash to the same segment
-	keys := findKeysInSameSegment(lru, 3)
+	keys := findKeysInSameSegment(lru.getSegment, 3)
 	k1, k2, k3 := keys[0], keys[1], keys[2]
 
 	// Add first entry (~9 bytes)
@@ -311,17 +317,33 @@ func TestCacheUseLRU_Stop(t *testing.T) {
 	}
 }
 
-// findKeysInSameSegment returns n keys that hash to the same segment
-func findKeysInSameSegment(lru *CacheUseLRU, n int) []string {
-	seen := make(map[*segment][]string)
-	i := 0
-	for {
-		key := fmt.Sprintf("key-%d", i)
-		seg := lru.getSegment(key)
-		seen[seg] = append(seen[seg], key)
-		if len(seen[seg]) == n {
-			return seen[seg]
-		}
-		i++
+// expiringString is a Value with its own absolute expiry time.
+type expiringString struct {
+	String
+	expireAt time.Time
+}
+
+func (e expiringString) IsExpired() bool {
+	return time.Now().After(e.expireAt)
+}
+
+func TestCacheUseLRU_CleanUpExpirable(t *testing.T) {
+	lru := NewCacheUseLRU(1024, nil)
+	defer lru.Stop()
+
+	lru.Put("short", expiringString{String("v1"), time.Now().Add(10 * time.Millisecond)})
+	lru.Put("long", expiringString{String("v2"), time.Now().Add(time.Hour)})
+
+	// Keep both entries hot, the idle ttl alone would never expire them
+	time.Sleep(20 * time.Millisecond)
+	lru.Get("short")
+	lru.Get("long")
+	lru.CleanUp(time.Hour)
+
+	if _, _, ok := lru.Get("short"); ok {
+		t.Error("entry past its own expiry time should have been reaped")
+	}
+	if _, _, ok := lru.Get("long"); !ok {
+		t.Error("entry before its expiry time should still be cached")
 	}
 }
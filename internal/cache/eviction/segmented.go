@@ -0,0 +1,95 @@
+package eviction
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// segmented is the part of a segmented cache that does not depend on its
+// eviction policy: the segments keys are spread over, each with its own lock,
+// and the routine removing expired entries. S is the segment type of the cache.
+type segmented[S any] struct {
+	segments        []S
+	numSegments     int
+	cleanupInterval time.Duration
+	ttl             time.Duration
+	stopCleanup     chan struct{}
+	cleanUp         func(ttl time.Duration) // CleanUp of the cache embedding it
+	mu              sync.RWMutex
+}
+
+// init creates the segments with newSegment, each of them given its share of
+// maxBytes, and starts the cleanup routine calling cleanUp.
+func (c *segmented[S]) init(maxBytes int64, newSegment func(maxBytes int64) S, cleanUp func(ttl time.Duration)) {
+	c.segments = make([]S, defaultNumSegments)
+	c.numSegments = defaultNumSegments
+	c.cleanupInterval = defaultCleanupInterval
+	c.ttl = defaultTTL
+	c.stopCleanup = make(chan struct{})
+	c.cleanUp = cleanUp
+
+	// Initialize segments
+	segmentMaxBytes := maxBytes / int64(defaultNumSegments)
+	for i := range c.segments {
+		c.segments[i] = newSegment(segmentMaxBytes)
+	}
+
+	// Start cleanup routine
+	go c.cleanupRoutine(c.stopCleanup, c.cleanupInterval)
+}
+
+// getSegment returns the appropriate segment for a given key.
+func (c *segmented[S]) getSegment(key string) S {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.segments[h.Sum32()%uint32(c.numSegments)]
+}
+
+// SetTTL sets the time-to-live for cache entries.
+func (c *segmented[S]) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// SetCleanupInterval sets the interval between cleanup runs.
+func (c *segmented[S]) SetCleanupInterval(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Stop existing cleanup routine
+	close(c.stopCleanup)
+
+	// Create new stop channel and set new interval
+	c.stopCleanup = make(chan struct{})
+	c.cleanupInterval = interval
+
+	// Start new cleanup routine
+	go c.cleanupRoutine(c.stopCleanup, interval)
+}
+
+// Stop stops the cleanup routine.
+func (c *segmented[S]) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.stopCleanup)
+}
+
+// cleanupRoutine periodically cleans up expired entries across all segments.
+func (c *segmented[S]) cleanupRoutine(stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.RLock()
+			currentTTL := c.ttl
+			c.mu.RUnlock()
+			loggerInstance.Infof("cleanupRoutine triggered after %v", interval)
+			c.cleanUp(currentTTL)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package eviction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// findKeysInSameSegment returns n keys that getSegment maps to the same segment
+func findKeysInSameSegment[S comparable](getSegment func(key string) S, n int) []string {
+	seen := make(map[S][]string)
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		seg := getSegment(key)
+		seen[seg] = append(seen[seg], key)
+		if len(seen[seg]) == n {
+			return seen[seg]
+		}
+	}
+}
+
+func TestSegmented_CleanupRoutine(t *testing.T) {
+	ttls := make(chan time.Duration, 1)
+	var c segmented[*struct{}]
+	c.init(0, func(int64) *struct{} { return new(struct{}) }, func(ttl time.Duration) {
+		select {
+		case ttls <- ttl:
+		default:
+		}
+	})
+	defer c.Stop()
+
+	c.SetTTL(time.Minute)
+	c.SetCleanupInterval(10 * time.Millisecond)
+	select {
+	case ttl := <-ttls:
+		if ttl != time.Minute {
+			t.Errorf("cleanup ran with ttl %v, want %v", ttl, time.Minute)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cleanup routine did not run")
+	}
+}
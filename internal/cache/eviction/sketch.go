@@ -0,0 +1,82 @@
+package eviction
+
+import "hash/fnv"
+
+const (
+	sketchDepth      = 4  // Number of hash rows in the count-min sketch
+	maxSketchCounter = 15 // Counters saturate like Caffeine's 4-bit counters
+	sketchResetRatio = 10 // Halve all counters after width*sketchResetRatio increments
+)
+
+// countMinSketch is a small count-min sketch used to estimate how often a key
+// has been seen recently. It is not safe for concurrent use.
+// Every sampleSize increments all counters are halved, so the estimates only
+// reflect recent history.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+// newCountMinSketch creates a sketch whose rows have at least width counters.
+func newCountMinSketch(width int) *countMinSketch {
+	w := 16
+	for w < width {
+		w <<= 1
+	}
+	s := &countMinSketch{
+		mask:       uint64(w - 1),
+		sampleSize: w * sketchResetRatio,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// Increment records one occurrence of key.
+func (s *countMinSketch) Increment(key string) {
+	h1, h2 := sketchHash(key)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < maxSketchCounter {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// Estimate returns the estimated number of recent occurrences of key.
+func (s *countMinSketch) Estimate(key string) uint8 {
+	h1, h2 := sketchHash(key)
+	min := uint8(maxSketchCounter)
+	for i := range s.rows {
+		if v := s.rows[i][(h1+uint64(i)*h2)&s.mask]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// reset halves every counter so that old popularity decays.
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// sketchHash derives the two base hashes used for double hashing.
+func sketchHash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
@@ -1,10 +1,11 @@
-// Package eviction provides cache eviction strategies including LRU for now 
+// Package eviction provides cache eviction strategies including LRU, LFU, S3-FIFO, W-TinyLFU and ARC.
 // Each strategy implements different algorithms for determining which entries to remove
 // when the cache reaches its capacity.
 package eviction
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 )
@@ -15,10 +16,14 @@ type EvictionType int
 const (
 	// EvictionLRU represents Least Recently Used strategy
 	EvictionLRU EvictionType = iota
-	// TODO EvictionLFU represents Least Frequently Used strategy
+	// EvictionLFU represents Least Frequently Used strategy
 	EvictionLFU
-	// TODO EvictionFIFO represents First In First Out strategy
+	// EvictionFIFO represents First In First Out strategy (S3-FIFO)
 	EvictionFIFO
+	// EvictionTinyLFU represents Window TinyLFU, an LRU with frequency based admission
+	EvictionTinyLFU
+	// EvictionARC represents Adaptive Replacement Cache, balancing recency and frequency
+	EvictionARC
 )
 
 // String returns the string representation of EvictionType
@@ -30,6 +35,10 @@ func (e EvictionType) String() string {
 		return "lfu"
 	case EvictionFIFO:
 		return "fifo"
+	case EvictionTinyLFU:
+		return "tinylfu"
+	case EvictionARC:
+		return "arc"
 	default:
 		return "unknown"
 	}
@@ -44,6 +53,10 @@ func StringToEvictionType(s string) (EvictionType, error) {
 		return EvictionLFU, nil
 	case "fifo":
 		return EvictionFIFO, nil
+	case "tinylfu":
+		return EvictionTinyLFU, nil
+	case "arc":
+		return EvictionARC, nil
 	default:
 		return EvictionLRU, fmt.Errorf("invalid eviction type: %s", s)
 	}
@@ -51,7 +64,7 @@ func StringToEvictionType(s string) (EvictionType, error) {
 
 // IsValid checks if the EvictionType is valid
 func (e EvictionType) IsValid() bool {
-	return e >= EvictionLRU && e <= EvictionFIFO
+	return e >= EvictionLRU && e <= EvictionARC
 }
 
 // Value represents a value that can be stored in the cache.
@@ -61,6 +74,13 @@ type Value interface {
 	Len() int
 }
 
+// Expirable is implemented by values that carry their own absolute expiry time.
+// Strategies treat such values as expired during CleanUp regardless of the ttl.
+type Expirable interface {
+	// IsExpired reports whether the value has passed its expiry time.
+	IsExpired() bool
+}
+
 // CacheStrategy defines the interface that all cache eviction strategies must implement.
 type CacheStrategy interface {
 	// Get retrieves a value from the cache.
@@ -72,13 +92,21 @@ type CacheStrategy interface {
 	// one or more entries will be evicted according to the strategy.
 	Put(key string, value Value)
 
+	// Delete removes a key from the cache.
+	// It reports whether the key was present.
+	Delete(key string) bool
+
 	// CleanUp removes expired entries from the cache.
 	// An entry is considered expired if its last update time plus ttl
-	// is before the current time.
+	// is before the current time, or if its value is Expirable and expired.
 	CleanUp(ttl time.Duration)
 
 	// Len returns the number of items in the cache.
 	Len() int
+
+	// Entries returns a copy of the entries in the cache,
+	// from the most to the least recently used.
+	Entries() []Entry
 }
 
 // Entry represents a cache entry with its metadata.
@@ -89,7 +117,11 @@ type Entry struct {
 }
 
 // Expired checks if the entry has expired based on the given duration.
+// Values implementing Expirable also expire at their own expiry time.
 func (e *Entry) Expired(duration time.Duration) bool {
+	if v, ok := e.Value.(Expirable); ok && v.IsExpired() {
+		return true
+	}
 	if e.UpdateAt.IsZero() {
 		return false // Never expires if update time is not set
 	}
@@ -101,6 +133,11 @@ func (e *Entry) Touch() {
 	e.UpdateAt = time.Now()
 }
 
+// sortByRecency orders entries from the most to the least recently used.
+func sortByRecency(entries []Entry) {
+	slices.SortStableFunc(entries, func(a, b Entry) int { return b.UpdateAt.Compare(a.UpdateAt) })
+}
+
 // CacheConfig represents the configuration for a cache
 type CacheConfig struct {
 	MaxBytes        int64         `json:"max_bytes"`
@@ -118,6 +155,14 @@ func New(name string, maxBytes int64, onEvicted func(string, Value)) (CacheStrat
 	switch evictionType {
 	case EvictionLRU:
 		return NewCacheUseLRU(maxBytes, onEvicted), nil
+	case EvictionLFU:
+		return NewCacheUseLFU(maxBytes, onEvicted), nil
+	case EvictionFIFO:
+		return NewCacheUseFIFO(maxBytes, onEvicted), nil
+	case EvictionTinyLFU:
+		return NewCacheUseTinyLFU(maxBytes, onEvicted), nil
+	case EvictionARC:
+		return NewCacheUseARC(maxBytes, onEvicted), nil
 	default:
 		return nil, fmt.Errorf("unsupported cache strategy: %q", name)
 	}
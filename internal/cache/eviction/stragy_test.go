@@ -0,0 +1,81 @@
+package eviction
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheStrategy_Delete(t *testing.T) {
+	for _, name := range []string{"lru", "lfu", "fifo", "tinylfu", "arc"} {
+		t.Run(name, func(t *testing.T) {
+			var evicted []string
+			c, err := New(name, 16*1024, func(key string, _ Value) {
+				evicted = append(evicted, key)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 10; i++ {
+				c.Put(fmt.Sprintf("key%d", i), String("value"))
+			}
+
+			if !c.Delete("key3") {
+				t.Error("Delete of a present key should return true")
+			}
+			if c.Delete("key3") {
+				t.Error("Delete of a deleted key should return false")
+			}
+			if _, _, ok := c.Get("key3"); ok {
+				t.Error("deleted key should not be found")
+			}
+			if c.Len() != 9 {
+				t.Errorf("Len() = %d, want 9", c.Len())
+			}
+			if len(evicted) != 1 || evicted[0] != "key3" {
+				t.Errorf("OnEvicted calls = %v, want [key3]", evicted)
+			}
+
+			// The freed bytes are reusable and the key can be inserted again
+			c.Put("key3", String("new"))
+			if v, _, ok := c.Get("key3"); !ok || string(v.(String)) != "new" {
+				t.Errorf("Get after re-insert = %v, %v, want new", v, ok)
+			}
+		})
+	}
+}
+
+func TestCacheStrategy_Entries(t *testing.T) {
+	for _, name := range []string{"lru", "lfu", "fifo", "tinylfu", "arc"} {
+		t.Run(name, func(t *testing.T) {
+			c, err := New(name, 16*1024, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 10; i++ {
+				c.Put(fmt.Sprintf("key%d", i), String("value"))
+				time.Sleep(time.Millisecond)
+			}
+			c.Delete("key3")
+			c.Put("key5", String("new"))
+
+			entries := c.Entries()
+			if len(entries) != 9 {
+				t.Fatalf("Entries() returned %d entries, want 9", len(entries))
+			}
+			if entries[0].Key != "key5" || string(entries[0].Value.(String)) != "new" {
+				t.Errorf("Entries()[0] = %s=%v, want the last written key5=new", entries[0].Key, entries[0].Value)
+			}
+			for i := 1; i < len(entries); i++ {
+				if entries[i].Key == "key3" {
+					t.Error("Entries() should not return deleted keys")
+				}
+				if entries[i].UpdateAt.After(entries[i-1].UpdateAt) {
+					t.Errorf("Entries() not ordered from the most recently used: %s after %s", entries[i].Key, entries[i-1].Key)
+				}
+			}
+		})
+	}
+}
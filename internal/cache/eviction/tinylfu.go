@@ -0,0 +1,289 @@
+package eviction
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	windowPercent      = 1    // Share of a segment's bytes given to the admission window
+	protectedPercent   = 80   // Share of the main area reserved for the protected segment
+	defaultSketchWidth = 1024 // Counters per sketch row and segment
+)
+
+// Queues an entry of the W-TinyLFU cache can live in.
+const (
+	queueWindow = iota
+	queueProbation
+	queueProtected
+)
+
+// tinyLFUItem is a cache entry together with the queue it currently lives in.
+type tinyLFUItem struct {
+	entry *Entry
+	queue int
+}
+
+// tinyLFUSegment represents a portion of the W-TinyLFU cache with its own lock.
+type tinyLFUSegment struct {
+	mu             sync.Mutex
+	maxBytes       int64
+	windowMax      int64
+	protectedMax   int64
+	nbytes         int64
+	windowBytes    int64
+	protectedBytes int64
+	window         *list.List // admission window LRU
+	probation      *list.List // main area, entries seen once in main
+	protected      *list.List // main area, entries accessed again while in probation
+	cache          map[string]*list.Element
+	sketch         *countMinSketch
+	OnEvicted      func(key string, value Value)
+}
+
+// CacheUseTinyLFU implements a segmented W-TinyLFU cache.
+// New entries land in a small window LRU. When the window overflows, its
+// victim has to compete with the victim of the segmented main LRU, and a
+// count-min sketch of recent accesses decides which of the two is kept.
+// This keeps a stable hot set in the cache even under long scans of cold keys.
+type CacheUseTinyLFU struct {
+	segmented[*tinyLFUSegment]
+}
+
+// NewCacheUseTinyLFU creates a new segmented W-TinyLFU cache with the specified maximum size and eviction callback.
+func NewCacheUseTinyLFU(maxBytes int64, onEvicted func(string, Value)) *CacheUseTinyLFU {
+	c := &CacheUseTinyLFU{}
+	c.init(maxBytes, func(maxBytes int64) *tinyLFUSegment {
+		windowMax := maxBytes * windowPercent / 100
+		return &tinyLFUSegment{
+			maxBytes:     maxBytes,
+			windowMax:    windowMax,
+			protectedMax: (maxBytes - windowMax) * protectedPercent / 100,
+			window:       list.New(),
+			probation:    list.New(),
+			protected:    list.New(),
+			cache:        make(map[string]*list.Element),
+			sketch:       newCountMinSketch(defaultSketchWidth),
+			OnEvicted:    onEvicted,
+		}
+	}, c.CleanUp)
+	return c
+}
+
+// Get retrieves a value from the cache.
+// Misses are recorded in the sketch too, so that a key requested often
+// enough wins admission once it is finally loaded.
+func (c *CacheUseTinyLFU) Get(key string) (value Value, updateAt time.Time, ok bool) {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	seg.sketch.Increment(key)
+	if ele, ok := seg.cache[key]; ok {
+		seg.onAccess(ele)
+		item := ele.Value.(*tinyLFUItem)
+		item.entry.Touch()
+		return item.entry.Value, item.entry.UpdateAt, true
+	}
+	return nil, time.Time{}, false
+}
+
+// Put adds or updates a value in the cache.
+func (c *CacheUseTinyLFU) Put(key string, value Value) {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	seg.sketch.Increment(key)
+	newBytes := int64(len(key)) + int64(value.Len())
+
+	if ele, ok := seg.cache[key]; ok {
+		item := ele.Value.(*tinyLFUItem)
+		delta := newBytes - int64(len(item.entry.Key)) - int64(item.entry.Value.Len())
+		item.entry.Value = value
+		item.entry.Touch()
+		seg.nbytes += delta
+		switch item.queue {
+		case queueWindow:
+			seg.windowBytes += delta
+		case queueProtected:
+			seg.protectedBytes += delta
+		}
+		seg.onAccess(ele)
+	} else {
+		item := &tinyLFUItem{
+			entry: &Entry{
+				Key:      key,
+				Value:    value,
+				UpdateAt: time.Now(),
+			},
+			queue: queueWindow,
+		}
+		seg.cache[key] = seg.window.PushBack(item)
+		seg.nbytes += newBytes
+		seg.windowBytes += newBytes
+	}
+
+	if seg.maxBytes == 0 {
+		return
+	}
+
+	// Window victims compete for a place in the main area
+	for seg.windowBytes > seg.windowMax && seg.window.Len() > 0 {
+		seg.admit(seg.window.Front())
+	}
+
+	// Updates that grew an entry may still leave the segment too large
+	for seg.maxBytes < seg.nbytes && len(seg.cache) > 0 {
+		seg.evictOne()
+	}
+}
+
+// Delete removes a key from the cache.
+// The sketch keeps its frequency, so a re-inserted key is admitted as easily as before.
+func (c *CacheUseTinyLFU) Delete(key string) bool {
+	seg := c.getSegment(key)
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+
+	if ele, ok := seg.cache[key]; ok {
+		seg.removeElement(ele)
+		return true
+	}
+	return false
+}
+
+// CleanUp removes entries that have not been accessed within ttl.
+func (c *CacheUseTinyLFU) CleanUp(ttl time.Duration) {
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		for _, l := range []*list.List{seg.window, seg.probation, seg.protected} {
+			var next *list.Element
+			for e := l.Front(); e != nil; e = next {
+				next = e.Next()
+				if e.Value.(*tinyLFUItem).entry.Expired(ttl) {
+					seg.removeElement(e)
+				}
+			}
+		}
+		seg.mu.Unlock()
+	}
+}
+
+// Len returns the total number of items in the cache.
+func (c *CacheUseTinyLFU) Len() int {
+	total := 0
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		total += len(seg.cache)
+		seg.mu.Unlock()
+	}
+	return total
+}
+
+// Entries returns a copy of the entries in the cache, from the most to the least recently used.
+func (c *CacheUseTinyLFU) Entries() []Entry {
+	var entries []Entry
+	for _, seg := range c.segments {
+		seg.mu.Lock()
+		for _, ele := range seg.cache {
+			entries = append(entries, *ele.Value.(*tinyLFUItem).entry)
+		}
+		seg.mu.Unlock()
+	}
+	sortByRecency(entries)
+	return entries
+}
+
+// onAccess updates the position of an entry after it was read or written.
+// Entries hit while on probation are promoted to the protected segment.
+func (seg *tinyLFUSegment) onAccess(ele *list.Element) {
+	item := ele.Value.(*tinyLFUItem)
+	switch item.queue {
+	case queueWindow:
+		seg.window.MoveToBack(ele)
+	case queueProtected:
+		seg.protected.MoveToBack(ele)
+	case queueProbation:
+		seg.probation.Remove(ele)
+		item.queue = queueProtected
+		seg.cache[item.entry.Key] = seg.protected.PushBack(item)
+		seg.protectedBytes += itemBytes(item)
+
+		// Demote the least recently used protected entries back to probation
+		for seg.protectedBytes > seg.protectedMax && seg.protected.Len() > 1 {
+			front := seg.protected.Front()
+			demoted := seg.protected.Remove(front).(*tinyLFUItem)
+			demoted.queue = queueProbation
+			seg.protectedBytes -= itemBytes(demoted)
+			seg.cache[demoted.entry.Key] = seg.probation.PushBack(demoted)
+		}
+	}
+}
+
+// admit moves the window victim into the main area if it is estimated to be
+// more popular than the main victims it would displace, and evicts it otherwise.
+func (seg *tinyLFUSegment) admit(ele *list.Element) {
+	candidate := seg.window.Remove(ele).(*tinyLFUItem)
+	size := itemBytes(candidate)
+	seg.windowBytes -= size
+	mainMax := seg.maxBytes - seg.windowMax
+	candidateFreq := seg.sketch.Estimate(candidate.entry.Key)
+
+	// nbytes - windowBytes is the main area plus the detached candidate
+	for seg.nbytes-seg.windowBytes > mainMax {
+		victim := seg.probation.Front()
+		if victim == nil {
+			victim = seg.protected.Front()
+		}
+		if victim == nil || candidateFreq <= seg.sketch.Estimate(victim.Value.(*tinyLFUItem).entry.Key) {
+			seg.evictDetached(candidate)
+			return
+		}
+		seg.removeElement(victim)
+	}
+
+	candidate.queue = queueProbation
+	seg.cache[candidate.entry.Key] = seg.probation.PushBack(candidate)
+}
+
+// evictOne evicts the first entry found in probation, protected or window order.
+func (seg *tinyLFUSegment) evictOne() {
+	for _, l := range []*list.List{seg.probation, seg.protected, seg.window} {
+		if ele := l.Front(); ele != nil {
+			seg.removeElement(ele)
+			return
+		}
+	}
+}
+
+// removeElement removes an element from the queue it lives in.
+func (seg *tinyLFUSegment) removeElement(ele *list.Element) {
+	item := ele.Value.(*tinyLFUItem)
+	switch item.queue {
+	case queueWindow:
+		seg.window.Remove(ele)
+		seg.windowBytes -= itemBytes(item)
+	case queueProbation:
+		seg.probation.Remove(ele)
+	case queueProtected:
+		seg.protected.Remove(ele)
+		seg.protectedBytes -= itemBytes(item)
+	}
+	seg.evictDetached(item)
+}
+
+// evictDetached drops an item that is no longer linked into any queue.
+func (seg *tinyLFUSegment) evictDetached(item *tinyLFUItem) {
+	delete(seg.cache, item.entry.Key)
+	seg.nbytes -= itemBytes(item)
+	if seg.OnEvicted != nil {
+		seg.OnEvicted(item.entry.Key, item.entry.Value)
+	}
+}
+
+// itemBytes returns the number of bytes accounted for an item.
+func itemBytes(item *tinyLFUItem) int64 {
+	return int64(len(item.entry.Key)) + int64(item.entry.Value.Len())
+}
@@ -0,0 +1,202 @@
+package eviction
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestCacheUseTinyLFU_Basic(t *testing.T) {
+	c := NewCacheUseTinyLFU(1024, nil)
+	defer c.Stop()
+
+	c.Put("key1", String("value1"))
+	if v, _, ok := c.Get("key1"); !ok || string(v.(String)) != "value1" {
+		t.Errorf("Get after Put failed, got %v, want %v", v, "value1")
+	}
+
+	if _, _, ok := c.Get("missing"); ok {
+		t.Error("Get with missing key should return false")
+	}
+
+	c.Put("key1", String("value2"))
+	if v, _, ok := c.Get("key1"); !ok || string(v.(String)) != "value2" {
+		t.Errorf("Get after update failed, got %v, want %v", v, "value2")
+	}
+}
+
+func TestCacheUseTinyLFU_Admission(t *testing.T) {
+	var evicted []string
+	// 16 segments * 24 bytes: room for two entries of ~9 bytes per segment
+	c := NewCacheUseTinyLFU(384, func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	defer c.Stop()
+
+	keys := findKeysInSameSegment(c.getSegment, 3)
+	hot, cold, candidate := keys[0], keys[1], keys[2]
+
+	c.Put(hot, String("1234"))
+	for i := 0; i < 5; i++ {
+		c.Get(hot)
+	}
+	c.Put(cold, String("5678"))
+
+	// A key seen only once may not displace an entry seen as often
+	c.Put(candidate, String("9012"))
+	if _, _, ok := c.Get(candidate); ok {
+		t.Errorf("first-time candidate %s should have been rejected", candidate)
+	}
+
+	// Repeated misses make the candidate popular enough to be admitted
+	for i := 0; i < 3; i++ {
+		c.Get(candidate)
+	}
+	c.Put(candidate, String("9012"))
+	if _, _, ok := c.Get(candidate); !ok {
+		t.Errorf("popular candidate %s should have been admitted", candidate)
+	}
+	if _, _, ok := c.Get(hot); !ok {
+		t.Errorf("hot key %s should never be evicted", hot)
+	}
+	if _, _, ok := c.Get(cold); ok {
+		t.Errorf("cold key %s should have lost against the candidate", cold)
+	}
+
+	want := []string{candidate, cold}
+	if len(evicted) != len(want) || evicted[0] != want[0] || evicted[1] != want[1] {
+		t.Errorf("evicted = %v, want %v", evicted, want)
+	}
+}
+
+func TestCacheUseTinyLFU_ProtectedPromotion(t *testing.T) {
+	// 1000 bytes per segment, so the window only holds a single entry
+	c := NewCacheUseTinyLFU(16000, nil)
+	defer c.Stop()
+
+	keys := findKeysInSameSegment(c.getSegment, 2)
+	seg := c.getSegment(keys[0])
+	queueOf := func(key string) int {
+		seg.mu.Lock()
+		defer seg.mu.Unlock()
+		return seg.cache[key].Value.(*tinyLFUItem).queue
+	}
+
+	c.Put(keys[0], String("1234"))
+	if q := queueOf(keys[0]); q != queueWindow {
+		t.Fatalf("queue of new entry = %d, want window", q)
+	}
+
+	// The window overflows and hands its victim over to probation
+	c.Put(keys[1], String("5678"))
+	if q := queueOf(keys[0]); q != queueProbation {
+		t.Fatalf("queue after window overflow = %d, want probation", q)
+	}
+
+	c.Get(keys[0])
+	if q := queueOf(keys[0]); q != queueProtected {
+		t.Errorf("queue after hit on probation = %d, want protected", q)
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 5; i++ {
+		s.Increment("hot")
+	}
+	s.Increment("cold")
+
+	if got := s.Estimate("hot"); got < 5 {
+		t.Errorf("Estimate(hot) = %d, want at least 5", got)
+	}
+	if got := s.Estimate("missing"); got > s.Estimate("hot") {
+		t.Errorf("Estimate(missing) = %d should not exceed Estimate(hot)", got)
+	}
+
+	// Counters saturate instead of overflowing
+	for i := 0; i < 100; i++ {
+		s.Increment("hot")
+	}
+	if got := s.Estimate("hot"); got > maxSketchCounter {
+		t.Errorf("Estimate(hot) = %d, want at most %d", got, maxSketchCounter)
+	}
+
+	before := s.Estimate("hot")
+	s.reset()
+	if got := s.Estimate("hot"); got != before/2 {
+		t.Errorf("Estimate(hot) after reset = %d, want %d", got, before/2)
+	}
+}
+
+func TestNew_TinyLFU(t *testing.T) {
+	s, err := New("tinylfu", 1024, nil)
+	if err != nil {
+		t.Fatalf("New(tinylfu) returned error: %v", err)
+	}
+	if _, ok := s.(*CacheUseTinyLFU); !ok {
+		t.Errorf("New(tinylfu) returned %T, want *CacheUseTinyLFU", s)
+	}
+}
+
+func TestCacheUseTinyLFU_HitRatioBeatsLRU(t *testing.T) {
+	keys := hotColdWorkload(200000, 1000, 20000, 1)
+
+	lru := NewCacheUseLRU(20000, nil)
+	defer lru.Stop()
+	tiny := NewCacheUseTinyLFU(20000, nil)
+	defer tiny.Stop()
+
+	lruRatio, tinyRatio := hitRatio(lru, keys), hitRatio(tiny, keys)
+	if tinyRatio <= lruRatio {
+		t.Errorf("tinylfu hit ratio %.3f should beat lru hit ratio %.3f", tinyRatio, lruRatio)
+	}
+}
+
+// BenchmarkHitRatio compares the hit ratio of every strategy on the hot/cold
+// workload of the gRPC test client, with a cache too small for all hot keys.
+func BenchmarkHitRatio(b *testing.B) {
+	keys := hotColdWorkload(200000, 1000, 20000, 1)
+
+	for _, name := range []string{"lru", "lfu", "fifo", "tinylfu", "arc"} {
+		b.Run(name, func(b *testing.B) {
+			var ratio float64
+			for i := 0; i < b.N; i++ {
+				s, err := New(name, 20000, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				ratio = hitRatio(s, keys)
+				s.(interface{ Stop() }).Stop()
+			}
+			b.ReportMetric(ratio*100, "hit%")
+		})
+	}
+}
+
+// hotColdWorkload mirrors test/grpc/grpc_client.go at a larger scale:
+// 80% of the requests go to the hot keys and 20% to the cold ones.
+func hotColdWorkload(n, hotKeys, coldKeys int, seed int64) []string {
+	r := rand.New(rand.NewSource(seed))
+	keys := make([]string, n)
+	for i := range keys {
+		if r.Intn(100) < 80 {
+			keys[i] = fmt.Sprintf("CNF-H%05d", r.Intn(hotKeys))
+		} else {
+			keys[i] = fmt.Sprintf("CNF-C%05d", r.Intn(coldKeys))
+		}
+	}
+	return keys
+}
+
+// hitRatio replays a workload against a strategy, loading every miss.
+func hitRatio(s CacheStrategy, keys []string) float64 {
+	hits := 0
+	for _, k := range keys {
+		if _, _, ok := s.Get(k); ok {
+			hits++
+			continue
+		}
+		s.Put(k, String("0123456789abcdef"))
+	}
+	return float64(hits) / float64(len(keys))
+}
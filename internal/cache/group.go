@@ -2,65 +2,139 @@ package cache
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"time"
 
-	cnfmetricspb "distcache/api/cnfmetricspb"
 	"distcache/config"
 	"distcache/internal/bussiness/cnf/db"
-
-	"gorm.io/gorm"
 )
 
 // NewGroupManager creates and initializes cache groups for the specified CNF metric types.
 // Returns a map of group names (metric types) to their corresponding Group instances.
+// A group whose cache cannot be created, e.g. because of an unknown strategy, is left out.
 func NewGroupManager(metricTypes []string, currentPeerAddr string) map[string]*Group {
     for _, metricType := range metricTypes {
         retriever := createCnfMetricRetriever()
         group := NewGroup(metricType, config.Conf.GroupManager.Strategy, config.Conf.GroupManager.MaxCacheSize, retriever)
+        if group == nil {
+            // NewGroup already logged why the cache could not be created
+            loggerInstance.Errorf("Group '%s' not created, check strategy '%s' in config", metricType, config.Conf.GroupManager.Strategy)
+            continue
+        }
+        group.SetTTL(time.Duration(config.Conf.GroupManager.TTL) * time.Second)
+        group.SetNegativeTTL(time.Duration(config.Conf.GroupManager.NegativeTTL) * time.Second)
+        if bloom := config.Conf.GroupManager.BloomFilter; bloom.FPRate > 0 {
+            if err := group.SetBloomFilter(createCnfIdLister(), bloom.FPRate, time.Duration(bloom.Rebuild)*time.Second); err != nil {
+                loggerInstance.Errorf("Group '%s' runs without bloom filter: %v", metricType, err)
+            }
+        }
+        group.SetTTLJitter(config.Conf.GroupManager.TTLJitter)
+        group.SetEarlyRefresh(config.Conf.GroupManager.EarlyRefresh)
+        group.SetStaleTTL(time.Duration(config.Conf.GroupManager.StaleTTL)*time.Second, time.Duration(config.Conf.GroupManager.MaxStaleness)*time.Second)
+        group.SetWriteOnFill(config.Conf.GroupManager.WriteOnFill)
+        hotCacheTTL := time.Duration(config.Conf.GroupManager.HotCacheTTL) * time.Second
+        if err := group.SetHotCache(config.Conf.GroupManager.HotCacheShare, hotCacheTTL); err != nil {
+            loggerInstance.Errorf("Group '%s' runs without hot cache: %v", metricType, err)
+        }
+        group.SetTopK(config.Conf.GroupManager.TopK)
+        group.SetHotKeyHook(config.Conf.GroupManager.HotKeyLogAt, func(group, key string, count int64) {
+            loggerInstance.Warnf("Group '%s' key '%s' is hot, %d requests", group, key, count)
+        })
+        setSnapshot(group, metricType)
         GroupManager[metricType] = group
         loggerInstance.Infof("Group '%s' created with strategy: '%s'", metricType, config.Conf.GroupManager.Strategy)
     }
     return GroupManager
 }
 
-// createCnfMetricRetriever sets up a RetrieveFunc to fetch CNF metric data from the database.
+// setSnapshot configures the snapshot of group from the snapshot settings of
+// the group manager, or from the ones of the group if it has its own.
+func setSnapshot(group *Group, name string) {
+    snapshot := config.Conf.GroupManager.Snapshot
+    if s, ok := config.Conf.GroupManager.Snapshots[name]; ok {
+        snapshot = s
+    }
+    if snapshot.Dir == "" {
+        return
+    }
+    if err := os.MkdirAll(snapshot.Dir, 0o755); err != nil {
+        loggerInstance.Errorf("Group '%s' runs without snapshot: %v", name, err)
+        return
+    }
+    group.SetSnapshot(filepath.Join(snapshot.Dir, name+".snap"), time.Duration(snapshot.Interval)*time.Second)
+}
+
+const (
+    minMetricTTL    = 10 * time.Second // lower bound for the lifetime of a cached metric
+    maxMetricTTL    = 10 * time.Minute // upper bound for the lifetime of a cached metric
+    metricTTLFactor = 10               // a metric unchanged for d is trusted for another d/metricTTLFactor
+)
+
+// metricTTL derives how long a metric stays valid from the time it was sampled.
+// Like HTTP heuristic freshness, metrics that have not changed for a long time
+// are assumed to be stable and are cached longer than freshly sampled ones.
+func metricTTL(timestamp time.Time) time.Duration {
+    ttl := time.Since(timestamp) / metricTTLFactor
+    if ttl < minMetricTTL {
+        return minMetricTTL
+    }
+    if ttl > maxMetricTTL {
+        return maxMetricTTL
+    }
+    return ttl
+}
+
+// createCnfIdLister lists the CNF IDs of the database, from which the Bloom filter of a group is built.
+func createCnfIdLister() ListKeysFunc {
+    return func() ([]string, error) {
+        return db.NewCnfMetricDb(context.Background()).ListCnfIds()
+    }
+}
+
+// createCnfMetricRetriever sets up a RetrieveMultiFunc to fetch CNF metric data from the database.
 // It logs query execution time and handles errors appropriately.
-// when cache is not hit, the group.getLocally func will call the retriever
-func createCnfMetricRetriever() RetrieveFunc {
-    return func(key string) ([]byte, error) {
+// when cache is not hit, the group.getLocally func will call the retriever with a single key,
+// while group.GetMulti loads all locally owned keys with one WHERE cnf_id IN (...) query.
+// The TTL of each item is derived from the metric's Timestamp, which also serves as its version.
+func createCnfMetricRetriever() RetrieveMultiFunc {
+    return func(ctx context.Context, keys []string) (map[string]Item, error) {
         start := time.Now()
         defer func() {
             loggerInstance.Debugf("Database query duration: %v ms", time.Since(start).Milliseconds())
         }()
 
-        ctx := context.Background()
+        // The query is bounded by the deadline of the request that missed
         cnfMetricDb := db.NewCnfMetricDb(ctx)
 
-        // Retrieve CNF metric information by key (CnfId).
-        cnfMetric, err := cnfMetricDb.ShowCnfMetric(&cnfmetricspb.CnfMetricRequest{CnfId: key})
+        // Retrieve CNF metric information by keys (CnfId).
+        cnfMetrics, err := cnfMetricDb.ListCnfMetrics(keys)
         if err != nil {
-            // Handle case where the record is not found.
-            if errors.Is(err, gorm.ErrRecordNotFound) {
-                loggerInstance.Infof("No CNF metric record found for key: '%s'", key)
-                return []byte{}, nil // Empty bytes indicate a negative cache result.
-            }
-            // Log and return other errors.
-            loggerInstance.Errorf("Failed to query database for key '%s': %v", key, err)
+            loggerInstance.Errorf("Failed to query database for %d keys: %v", len(keys), err)
             return nil, fmt.Errorf("database query error: %w", err)
         }
 
-        loggerInstance.Infof("Successfully retrieved CNF metric record: CnfId='%s'", key)
+        items := make(map[string]Item, len(keys))
+        for i := range cnfMetrics {
+            cnfMetric := &cnfMetrics[i]
 
-        // Serialize the full CnfMetric object into JSON for storage in the cache
-        metricJSON, err := json.Marshal(cnfMetric) // Calls MarshalJSON internally
-        if err != nil {
-            loggerInstance.Errorf("Failed to serialize CNF metric for key '%s': %v", key, err)
-            return nil, fmt.Errorf("serialization error: %w", err)
-        }
+            // Serialize the full CnfMetric object into JSON for storage in the cache
+            metricJSON, err := json.Marshal(cnfMetric) // Calls MarshalJSON internally
+            if err != nil {
+                loggerInstance.Errorf("Failed to serialize CNF metric for key '%s': %v", cnfMetric.CnfId, err)
+                return nil, fmt.Errorf("serialization error: %w", err)
+            }
 
-        return metricJSON, nil // Return the serialized metric as JSON
+            items[cnfMetric.CnfId] = Item{
+                Value:   metricJSON,
+                TTL:     metricTTL(cnfMetric.Timestamp),
+                Version: cnfMetric.Timestamp.Format(time.RFC3339Nano),
+            }
+        }
+        // Keys without a record are left out, the group caches them as not found.
+        loggerInstance.Infof("Successfully retrieved %d of %d CNF metric records", len(items), len(keys))
+        return items, nil
     }
 }
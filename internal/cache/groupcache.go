@@ -4,11 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"distcache/internal/metrics"
-	"gorm.io/gorm"
 )
 
 var (
@@ -16,13 +17,40 @@ var (
 	GroupManager = make(map[string]*Group)
 )
 
+const defaultNegativeTTL = 10 * time.Second // lifetime of the entry of a key missing from the backing store
+
 // Group represents a cache namespace and associated data/operations.
 type Group struct {
 	name      string
-	cache     *cache
+	strategy  string // eviction strategy of the caches
+	maxBytes  int64  // bytes shared by the main and the hot cache
+	mainCache *cache // values of the keys this node owns
 	retriever Retriever
 	server    Picker
 	flight    *FlightGroup
+
+	// Set up by SetHotCache before the group serves requests
+	hotCache     *cache        // copies of hot values owned by peers, nil when disabled
+	hotAdmission *hotAdmission // decides which values fetched from peers enter the hot cache
+	hotTTL       time.Duration // lifetime of a copy in the hot cache
+
+	filter     *keyFilter    // keys of the backing store, set up by SetBloomFilter, nil when disabled
+	hotKeys    *heavyHitters // most requested keys, fed by Get
+	refreshing sync.Map      // keys being refreshed in the background
+
+	// Set up by SetSnapshot before the group serves requests
+	snapshotPath string        // file holding the snapshot of the main cache, empty when disabled
+	snapshotStop chan struct{} // stops writing snapshots periodically
+
+	mu          sync.RWMutex  // protects ttl, negativeTTL, ttlJitter, revalidate, maxStale, beta, writeOnFill and hotKeyHook
+	ttl         time.Duration // lifetime of loaded entries, zero means no expiry
+	negativeTTL time.Duration // lifetime of the entries of keys missing from the backing store, zero disables them
+	ttlJitter   float64       // fraction of the ttl entries expire earlier or later by, at random
+	revalidate  time.Duration // past its expiry, an entry is served stale and refreshed in the background for so long
+	maxStale    time.Duration // past its expiry, an entry is served stale when it cannot be loaded for so long
+	beta        float64       // eagerness of the early refresh of entries about to expire, zero disables it
+	writeOnFill bool          // push values loaded by the owner to the replicas of the key
+	hotKeyHook  HotKeyHook    // called when a key reaches the hot key threshold
 }
 
 // NewGroup creates a new cache namespace with the specified configuration.
@@ -50,9 +78,14 @@ func NewGroup(name string, strategy string, maxBytes int64, retriever Retriever)
 
 	group := &Group{
 		name:      name,
-		cache:     cache,
+		strategy:  strategy,
+		maxBytes:  maxBytes,
+		mainCache: cache,
 		retriever: retriever,
 		flight:    NewFlightGroup(10 * time.Second),
+		hotKeys:   newHeavyHitters(defaultTopK),
+
+		negativeTTL: defaultNegativeTTL,
 	}
 
 	GroupManager[name] = group
@@ -68,6 +101,139 @@ func (g *Group) RegisterServer(p Picker) {
 	g.server = p
 }
 
+// SetTTL sets how long entries loaded into the group stay valid.
+// The expiry is absolute: it is measured from the time the entry was loaded,
+// not from its last access. A zero ttl disables expiry.
+func (g *Group) SetTTL(ttl time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ttl = ttl
+}
+
+// SetNegativeTTL sets how long the group remembers that a key is missing from
+// the backing store: Get answers ErrNotFound for it without asking the
+// retriever again, which protects the backing store from lookups of keys that
+// do not exist. A ttl of 0 disables negative caching.
+func (g *Group) SetNegativeTTL(ttl time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.negativeTTL = max(ttl, 0)
+}
+
+// SetTTLJitter spreads the lifetime of the entries given a ttl, by the group
+// or by the retriever, over ttl ± fraction*ttl, so that entries loaded at the
+// same time, as after a restart, do not all expire at once. fraction is
+// clamped to [0, 1], 0 disables the jitter.
+func (g *Group) SetTTLJitter(fraction float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ttlJitter = min(max(fraction, 0), 1)
+}
+
+// SetEarlyRefresh makes requests reload entries loaded by this node before
+// they expire, with a probability rising as the expiry approaches and with the
+// time the entry took to load, see refreshEarly. The entry keeps being served
+// while it is reloaded in the background. beta scales how early entries are
+// refreshed, 1 is the usual choice, 0 disables early refresh.
+func (g *Group) SetEarlyRefresh(beta float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.beta = max(beta, 0)
+}
+
+// earlyRefreshBeta returns the eagerness of the early refresh, see SetEarlyRefresh.
+func (g *Group) earlyRefreshBeta() float64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.beta
+}
+
+// SetStaleTTL lets the group serve entries past their expiry. The expiry of an
+// entry, set by SetTTL or by the retriever, is its soft ttl; the soft ttl plus
+// revalidate is its hard ttl. Between the two, Get returns the stale entry at
+// once and reloads it in the background through the flight group. Past the hard
+// ttl Get reloads the entry, and should the retriever or the owning peer fail,
+// it returns the stale entry as long as it expired less than maxStale ago.
+// Stale values are flagged, see ByteView.Stale. Zero durations disable either.
+func (g *Group) SetStaleTTL(revalidate, maxStale time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.revalidate, g.maxStale = max(revalidate, 0), max(maxStale, 0)
+}
+
+// SetHotCache gives share, between 0 and 1, of the bytes of the group to a hot
+// cache, as in groupcache. Values fetched from peers are usually not kept, every
+// request for a key owned by a peer costs an RPC. With a hot cache, the keys
+// fetched most often keep a local copy for ttl, 0 meaning defaultHotCacheTTL.
+// The main cache, holding the keys this node owns, keeps the other bytes.
+// A share of 0 disables the hot cache. It must be called before the group
+// serves requests, cached values are dropped.
+func (g *Group) SetHotCache(share float64, ttl time.Duration) error {
+	if share < 0 || share >= 1 {
+		return fmt.Errorf("hot cache share must be in [0, 1), got %v", share)
+	}
+	if ttl <= 0 {
+		ttl = defaultHotCacheTTL
+	}
+
+	hotBytes := int64(float64(g.maxBytes) * share)
+	mainCache, err := NewCache(g.strategy, g.maxBytes-hotBytes)
+	if err != nil {
+		return err
+	}
+	var hotCache *cache
+	if hotBytes > 0 {
+		if hotCache, err = NewCache(g.strategy, hotBytes); err != nil {
+			mainCache.stop()
+			return err
+		}
+	}
+
+	g.mainCache.stop()
+	g.hotCache.stop()
+	g.mainCache, g.hotCache = mainCache, hotCache
+	g.hotAdmission, g.hotTTL = newHotAdmission(hotSampleRate), ttl
+	return nil
+}
+
+// SetWriteOnFill makes the owner of a key push the value it loaded from the
+// retriever to the other replicas of the key, see Server.SetReplicationFactor,
+// so that they can serve it from their cache should the owner fail.
+// Values written with Set are pushed to the replicas as well.
+func (g *Group) SetWriteOnFill(enabled bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeOnFill = enabled
+}
+
+// SetTopK sets how many of its most requested keys the group reports,
+// 0 or less meaning defaultTopK. It must be called before the group serves
+// requests, the requests counted so far are dropped.
+func (g *Group) SetTopK(k int) {
+	g.hotKeys = newHeavyHitters(k)
+}
+
+// SetHotKeyHook calls hook whenever a key of the group is requested threshold
+// times, so that other components can react to hot keys, for instance by
+// replicating or rate limiting them. Counts are halved periodically, a key
+// that cooled down and heats up again reaches the threshold once more.
+// A threshold of 0 or less, or a nil hook, removes the hook.
+func (g *Group) SetHotKeyHook(threshold int64, hook HotKeyHook) {
+	if hook == nil {
+		threshold = 0
+	}
+	g.mu.Lock()
+	g.hotKeyHook = hook
+	g.mu.Unlock()
+	g.hotKeys.setThreshold(max(threshold, 0))
+}
+
+// TopKeys returns the n most requested keys of the group, most requested
+// first. n of 0 or less reports as many as configured with SetTopK.
+func (g *Group) TopKeys(n int) []KeyCount {
+	return g.hotKeys.top(n)
+}
+
 // GetGroup retrieves a Group by name from the GroupManager.
 func GetGroup(name string) *Group {
 	mu.RLock()
@@ -75,6 +241,17 @@ func GetGroup(name string) *Group {
 	return GroupManager[name]
 }
 
+// allGroups returns the groups of the GroupManager.
+func allGroups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	groups := make([]*Group, 0, len(GroupManager))
+	for _, g := range GroupManager {
+		groups = append(groups, g)
+	}
+	return groups
+}
+
 // DestroyGroup removes a Group and stops its associated server.
 func DestroyGroup(name string) {
 	g := GetGroup(name)
@@ -88,6 +265,8 @@ func DestroyGroup(name string) {
 		if g.flight != nil {
 			g.flight.Stop()
 		}
+		g.stopSnapshots()
+		g.stopBloomFilter()
 		mu.Lock()
 		delete(GroupManager, name)
 		mu.Unlock()
@@ -96,36 +275,499 @@ func DestroyGroup(name string) {
 
 // Get retrieves a value from the cache by key.
 // If the key doesn't exist in cache, it loads it using the configured retriever.
-func (g *Group) Get(key string) (ByteView, error) {
+// It gives up with the error of ctx once ctx is done. Concurrent loads of a key
+// are shared, and run on the peer owning the key and in the retriever until the
+// deadline of the caller that started them or flightTimeout, whichever is
+// later: a caller giving up early does not fail the others.
+func (g *Group) Get(ctx context.Context, key string) (ByteView, error) {
+	return g.get(ctx, key, g.server)
+}
+
+// getForPeer serves a key a peer asked for. The peer already routed the key
+// here, so it is loaded locally instead of being routed again: with bounded
+// loads a node is also asked for keys it does not own, and routing those on
+// could bounce a request between overloaded nodes.
+func (g *Group) getForPeer(ctx context.Context, key string) (ByteView, error) {
+	return g.get(ctx, key, nil)
+}
+
+// get looks key up in the cache and loads it on a miss, from the peer picker
+// selects or locally if picker is nil or selects this node.
+func (g *Group) get(ctx context.Context, key string, picker Picker) (ByteView, error) {
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key cannot be empty")
+		return ByteView{}, fmt.Errorf("%w: key cannot be empty", ErrInvalidKey)
 	}
 
 	metrics.RecordRequest()
+	g.observeKey(key)
 
-	if value, ok := g.cache.get(key); ok {
+	cached, ok := g.lookupCache(key)
+	if ok && cached.notFound {
+		return ByteView{}, fmt.Errorf("key %q: %w", key, ErrNotFound)
+	}
+	if ok && !cached.needsRefresh() {
 		loggerInstance.Infof("Group %s cache hit ..., key %s...", g.name, key)
-		return value, nil
+		if g.refreshEarly(cached) {
+			metrics.RecordEarlyRefresh()
+			g.refreshInBackground(key, picker)
+		}
+		return cached, nil
 	}
 
-	return g.load(key)
+	revalidate, maxStale := g.staleTTL()
+	if ok && time.Now().Before(cached.expireAt.Add(revalidate)) {
+		loggerInstance.Infof("Group %s serving stale key %s while revalidating it", g.name, key)
+		metrics.RecordStaleServed("revalidate")
+		g.refreshInBackground(key, picker)
+		cached.stale = true
+		return cached, nil
+	}
+
+	// A key missing from the backing store was deleted, its stale value is not served
+	value, err := g.loadFresh(ctx, key, picker)
+	if err != nil && !isNotFound(err) && ok && time.Now().Before(cached.expireAt.Add(maxStale)) {
+		loggerInstance.Warnf("Group %s serving stale key %s, loading it failed: %v", g.name, key, err)
+		metrics.RecordStaleServed("error")
+		cached.stale = true
+		return cached, nil
+	}
+	return value, err
 }
 
-// load retrieves data for a key, either from a peer or locally.
-// It uses FlightGroup to prevent thundering herd.
-func (g *Group) load(key string) (value ByteView, err error) {
-	ctx := context.Background()
-	viewi, err := g.flight.Do(ctx, key, func() (interface{}, error) {
-		if g.server != nil {
-			if peer, ok := g.server.Pick(key); ok {
-				if value, err = g.fetchFromPeer(peer, key); err == nil {
-					return value, nil
-				}
+// loadFresh loads key, and once more if the flight group returned a result
+// past its expiry: it can hold a result longer than the entry ttl.
+func (g *Group) loadFresh(ctx context.Context, key string, picker Picker) (ByteView, error) {
+	value, err := g.load(ctx, key, picker)
+	if err == nil && value.needsRefresh() && !value.stale {
+		g.flight.ForceEvict(key)
+		return g.load(ctx, key, picker)
+	}
+	return value, err
+}
+
+// staleTTL returns how long past their expiry entries are served stale while
+// being revalidated, and when they cannot be loaded.
+func (g *Group) staleTTL() (revalidate, maxStale time.Duration) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.revalidate, g.maxStale
+}
+
+// observeKey counts a request for key in the heavy hitters of the group and
+// calls the hot key hook if the request made key reach the threshold.
+func (g *Group) observeKey(key string) {
+	count, hot := g.hotKeys.observe(key)
+	if !hot {
+		return
+	}
+	g.mu.RLock()
+	hook := g.hotKeyHook
+	g.mu.RUnlock()
+	if hook != nil {
+		hook(g.name, key, count)
+	}
+}
+
+// lookupCache looks key up in the hot cache, then in the main cache.
+// A key is in at most one of them: the hot cache only holds keys of peers.
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if value, ok := g.hotCache.lookup(key); ok {
+		metrics.RecordCacheHit()
+		metrics.RecordHotCacheHit()
+		return value, true
+	}
+	return g.mainCache.get(key)
+}
+
+// GetMulti retrieves the values of many keys at once.
+// Cache misses are grouped by the peer owning them and fetched with one request
+// per peer, while keys owned by this node are loaded with a single batch query
+// when the retriever implements BatchRetriever. Keys that could not be loaded
+// are missing from the returned map, load failures are joined into the
+// returned error. Keys missing from the backing store are left out as well,
+// those a peer reports missing are not loaded locally again. Like Get, expired
+// entries are served stale within the windows set by SetStaleTTL.
+func (g *Group) GetMulti(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values, err := g.getMulti(ctx, keys, g.server)
+	maps.DeleteFunc(values, func(key string, value ByteView) bool {
+		return value.notFound
+	})
+	return values, err
+}
+
+// getMultiForPeer serves keys a peer asked for, loading the misses locally like getForPeer.
+func (g *Group) getMultiForPeer(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	return g.getMulti(ctx, keys, nil)
+}
+
+// getMulti is GetMulti routing the misses with picker, or loading them all locally if picker is nil.
+// Keys missing from the backing store are mapped to a value with notFound set.
+func (g *Group) getMulti(ctx context.Context, keys []string, picker Picker) (map[string]ByteView, error) {
+	values := make(map[string]ByteView, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	revalidate, maxStale := g.staleTTL()
+	fallback := make(map[string]ByteView) // expired entries served if loading them fails
+	var missed []string
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("%w: key cannot be empty", ErrInvalidKey)
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		metrics.RecordRequest()
+		g.observeKey(key)
+		cached, ok := g.lookupCache(key)
+		if ok && !cached.needsRefresh() {
+			values[key] = cached
+			continue
+		}
+		if ok && !cached.notFound && time.Now().Before(cached.expireAt.Add(revalidate)) {
+			metrics.RecordStaleServed("revalidate")
+			g.refreshInBackground(key, picker)
+			cached.stale = true
+			values[key] = cached
+			continue
+		}
+		if ok && !cached.notFound && time.Now().Before(cached.expireAt.Add(maxStale)) {
+			fallback[key] = cached
+		}
+		missed = append(missed, key)
+	}
+	if len(missed) == 0 {
+		return values, nil
+	}
+
+	local, byPeer := splitByOwner(picker, missed)
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for peer, peerKeys := range byPeer {
+		wg.Add(1)
+		go func(peer Fetcher, peerKeys []string) {
+			defer wg.Done()
+			fetched, failed := g.fetchMultiFromPeer(ctx, peer, peerKeys)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for key, value := range fetched {
+				values[key] = value
+			}
+			// Like Get, fall back to the local retriever when the owner fails
+			local = append(local, failed...)
+		}(peer, peerKeys)
+	}
+	wg.Wait()
+
+	loaded, err := g.getMultiLocally(ctx, local)
+	for key, value := range loaded {
+		values[key] = value
+	}
+
+	if err == nil {
+		return values, nil
+	}
+
+	// Keys that failed to load are served stale while within maxStale. A key
+	// missing from the backing store was deleted, its stale value is not served.
+	failed := 0
+	for _, key := range missed {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		cached, ok := fallback[key]
+		if !ok {
+			failed++
+			continue
+		}
+		loggerInstance.Warnf("Group %s serving stale key %s, loading it failed", g.name, key)
+		metrics.RecordStaleServed("error")
+		cached.stale = true
+		values[key] = cached
+	}
+	if failed == 0 {
+		return values, nil
+	}
+	return values, err
+}
+
+// splitByOwner separates keys picker assigns to this node from keys assigned
+// to peers, grouping the latter by peer.
+func splitByOwner(picker Picker, keys []string) ([]string, map[Fetcher][]string) {
+	if picker == nil {
+		return keys, nil
+	}
+
+	var local []string
+	byPeer := make(map[Fetcher][]string)
+	for _, key := range keys {
+		if peer, ok := picker.Pick(key); ok {
+			byPeer[peer] = append(byPeer[peer], key)
+		} else {
+			local = append(local, key)
+		}
+	}
+	return local, byPeer
+}
+
+// fetchMultiFromPeer retrieves keys from a peer in one request if the peer
+// supports it, and one by one otherwise. It returns the fetched values, with
+// notFound set for the keys the peer reports missing from the backing store,
+// and the keys the peer failed to serve.
+func (g *Group) fetchMultiFromPeer(ctx context.Context, peer Fetcher, keys []string) (map[string]ByteView, []string) {
+	values := make(map[string]ByteView, len(keys))
+	var failed []string
+
+	mf, ok := peer.(MultiFetcher)
+	if !ok {
+		for _, key := range keys {
+			value, err := g.fetchFromPeer(ctx, peer, key)
+			if isNotFound(err) {
+				values[key] = ByteView{notFound: true}
+				continue
+			}
+			if err != nil {
 				loggerInstance.Warnf("failed to get from peer: %v", err)
+				failed = append(failed, key)
+				continue
 			}
+			g.populateHotCache(key, value)
+			values[key] = value
 		}
+		return values, failed
+	}
 
-		return g.getLocally(key)
+	items, err := mf.FetchMulti(ctx, g.name, keys)
+	if err != nil {
+		loggerInstance.Warnf("failed to get %d keys from peer: %v", len(keys), err)
+		return nil, keys
+	}
+	for _, key := range keys {
+		item, ok := items[key]
+		if !ok {
+			failed = append(failed, key)
+			continue
+		}
+		if item.NotFound {
+			values[key] = ByteView{notFound: true}
+			continue
+		}
+		value := ByteView{b: cloneBytes(item.Value), expireAt: item.ExpireAt, version: item.Version, stale: item.Stale}
+		g.populateHotCache(key, value)
+		values[key] = value
+	}
+	return values, failed
+}
+
+// getMultiLocally loads keys from the retriever and populates the cache.
+// Batch retrievers are asked once for all keys, other retrievers once per key.
+// Keys missing from the backing store are mapped to a value with notFound set.
+func (g *Group) getMultiLocally(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values := make(map[string]ByteView, len(keys))
+	br, ok := g.retriever.(BatchRetriever)
+	if !ok {
+		var errs []error
+		for _, key := range keys {
+			value, err := g.getLocally(ctx, key)
+			if isNotFound(err) {
+				values[key] = ByteView{notFound: true}
+				continue
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			values[key] = value
+		}
+		return values, errors.Join(errs...)
+	}
+
+	keys = slices.DeleteFunc(slices.Clone(keys), func(key string) bool {
+		if g.mayExist(key) {
+			return false
+		}
+		metrics.RecordBloomFiltered()
+		values[key] = ByteView{notFound: true}
+		return true
+	})
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.ObserveRequestDuration("put", time.Since(start).Seconds()*1000)
+	}()
+	items, err := br.retrieveMulti(ctx, keys)
+	if err != nil {
+		metrics.RecordDatabaseMiss()
+		return nil, fmt.Errorf("failed to retrieve %d keys locally: %w", len(keys), err)
+	}
+
+	for _, key := range keys {
+		item, ok := items[key]
+		if !ok {
+			metrics.RecordDatabaseMiss()
+			g.cacheNotFound(key)
+			values[key] = ByteView{notFound: true}
+			continue
+		}
+		metrics.RecordDatabaseHit()
+
+		value := ByteView{b: cloneBytes(item.Value), expireAt: g.expireAt(item), version: item.Version, loadTime: time.Since(start)}
+		g.populateCache(key, value)
+		values[key] = value
+	}
+	return values, nil
+}
+
+// Set stores value under key, overwriting any cached value.
+// A zero ttl falls back to the group ttl. When the key is owned by a peer,
+// the value is written to the owner and the local copy is purged.
+// The deadline of ctx, if any, bounds the requests to the peers.
+func (g *Group) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", ErrInvalidKey)
+	}
+
+	item := Item{Value: value}
+	if ttl > 0 {
+		// Send an absolute expiry so the owner does not restart the clock
+		item.ExpireAt = time.Now().Add(ttl)
+	}
+
+	if g.server == nil {
+		g.setLocally(key, item)
+		return nil
+	}
+
+	peer, ok := g.server.Pick(key)
+	if ok {
+		g.purge(key)
+		if err := peer.Set(ctx, g.name, key, item); err != nil {
+			return fmt.Errorf("failed to set key %q on peer: %w", key, err)
+		}
+	} else {
+		g.setLocally(key, item)
+	}
+
+	// The replicas must not keep serving the old value should the owner fail
+	replicas := without(g.server.PickReplicas(key), peer)
+	var err error
+	if g.isWriteOnFill() {
+		err = g.setOnPeers(ctx, key, item, replicas)
+	} else {
+		err = g.deleteOnPeers(ctx, key, replicas)
+	}
+	if err != nil {
+		loggerInstance.Warnf("failed to update replicas of key %q: %v", key, err)
+	}
+	return nil
+}
+
+// Remove evicts key from the cache of its owner and from the local cache.
+// The next Get loads the key from the retriever again.
+// The deadline of ctx, if any, bounds the requests to the peers.
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: key cannot be empty", ErrInvalidKey)
+	}
+
+	g.purge(key)
+
+	if g.server == nil {
+		return nil
+	}
+
+	peer, ok := g.server.Pick(key)
+	if ok {
+		if err := peer.Delete(ctx, g.name, key); err != nil {
+			return fmt.Errorf("failed to remove key %q on peer: %w", key, err)
+		}
+	}
+	if err := g.deleteOnPeers(ctx, key, without(g.server.PickReplicas(key), peer)); err != nil {
+		loggerInstance.Warnf("failed to remove replicas of key %q: %v", key, err)
+	}
+	return nil
+}
+
+// setLocally stores an item in the local cache and drops any result the
+// flight group still holds for the key.
+func (g *Group) setLocally(key string, item Item) {
+	if g.filter != nil {
+		g.filter.add(key)
+	}
+	g.populateCache(key, ByteView{b: cloneBytes(item.Value), expireAt: g.expireAt(item), version: item.Version})
+	g.hotCache.remove(key)
+	g.flight.ForceEvict(key)
+}
+
+// acceptHandoff stores an entry handed over by the previous owner of key unless
+// key is already cached or the entry expired. It reports whether it was stored.
+func (g *Group) acceptHandoff(key string, item Item) bool {
+	if !item.ExpireAt.IsZero() && !item.ExpireAt.After(time.Now()) {
+		return false
+	}
+	if g.mainCache.contains(key) {
+		return false
+	}
+	g.populateCache(key, ByteView{b: cloneBytes(item.Value), expireAt: g.expireAt(item), version: item.Version})
+	return true
+}
+
+// purge removes key from the local caches and the flight group results.
+func (g *Group) purge(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.flight.ForceEvict(key)
+}
+
+// load retrieves data for a key, either from the peer picker selects or locally.
+// When that peer fails, the other replicas of the key are tried in turn before
+// the retriever, so that a failing node does not send all its keys to the
+// backing store. A key the peer reports missing is not loaded locally again.
+// It uses FlightGroup to prevent thundering herd: the load is shared by the
+// concurrent callers of key, each of them gives up when its own ctx is done.
+func (g *Group) load(ctx context.Context, key string, picker Picker) (ByteView, error) {
+	viewi, err := g.flight.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		if picker == nil {
+			return g.getLocally(ctx, key)
+		}
+
+		peer, ok := picker.Pick(key)
+		if ok {
+			value, err := g.fetchFromReplicas(ctx, key, peer, picker.PickReplicas(key))
+			if err == nil || isNotFound(err) {
+				// the owner loaded the key from the same backing store, asking it again is no use
+				return value, err
+			}
+			if ctx.Err() != nil {
+				return ByteView{}, err
+			}
+			loggerInstance.Warnf("failed to get from peer: %v", err)
+			return g.getLocally(ctx, key)
+		}
+
+		value, err := g.getLocally(ctx, key)
+		if err == nil && g.isWriteOnFill() {
+			item, replicas := Item{Value: value.b, ExpireAt: value.expireAt, Version: value.version}, picker.PickReplicas(key)
+			// The fill outlives the load, it keeps the values of ctx but not its deadline
+			ctx := context.WithoutCancel(ctx)
+			go func() {
+				if err := g.setOnPeers(ctx, key, item, replicas); err != nil {
+					loggerInstance.Warnf("failed to fill replicas of key %q: %v", key, err)
+				}
+			}()
+		}
+		return value, err
 	})
 
 	if err != nil {
@@ -136,42 +778,145 @@ func (g *Group) load(key string) (value ByteView, err error) {
 }
 
 // fetchFromPeer retrieves data from a peer cache node.
-func (g *Group) fetchFromPeer(peer Fetcher, key string) (ByteView, error) {
+func (g *Group) fetchFromPeer(ctx context.Context, peer Fetcher, key string) (ByteView, error) {
 	loggerInstance.Infof("fetchFromPeer peer is %+v", peer)
-	bytes, err := peer.Fetch(g.name, key)
+	item, err := peer.Fetch(ctx, g.name, key)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: cloneBytes(bytes)}, nil
+	return ByteView{b: cloneBytes(item.Value), expireAt: item.ExpireAt, version: item.Version, stale: item.Stale}, nil
+}
+
+// fetchFromReplicas retrieves data from peer, then from the other replicas in order
+// until one of them serves it or reports the key missing from the backing store.
+// It returns the errors of all of them if none does.
+func (g *Group) fetchFromReplicas(ctx context.Context, key string, peer Fetcher, replicas []Fetcher) (ByteView, error) {
+	var errs []error
+	for _, replica := range append([]Fetcher{peer}, without(replicas, peer)...) {
+		value, err := g.fetchFromPeer(ctx, replica, key)
+		if err == nil {
+			if len(errs) > 0 {
+				metrics.RecordReplicaFallback()
+			}
+			g.populateHotCache(key, value)
+			return value, nil
+		}
+		if isNotFound(err) {
+			return ByteView{}, err
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break // the other replicas would not answer in time either
+		}
+	}
+	return ByteView{}, errors.Join(errs...)
+}
+
+// setOnPeers writes item under key to every peer, returning the errors of those that failed.
+func (g *Group) setOnPeers(ctx context.Context, key string, item Item, peers []Fetcher) error {
+	var errs []error
+	for _, peer := range peers {
+		if err := peer.Set(ctx, g.name, key, item); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteOnPeers removes key from every peer, returning the errors of those that failed.
+func (g *Group) deleteOnPeers(ctx context.Context, key string, peers []Fetcher) error {
+	var errs []error
+	for _, peer := range peers {
+		if err := peer.Delete(ctx, g.name, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// without returns peers with peer left out.
+func without(peers []Fetcher, peer Fetcher) []Fetcher {
+	if peer == nil {
+		return peers
+	}
+	return slices.DeleteFunc(slices.Clone(peers), func(p Fetcher) bool { return p == peer })
+}
+
+// isWriteOnFill reports whether loaded values are pushed to the replicas.
+func (g *Group) isWriteOnFill() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.writeOnFill
 }
 
 // getLocally retrieves data from the configured retriever and populates the cache.
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
 	// put menas we need to retrieve the data from db and load into the cache
 	start := time.Now()
 	defer func() {
 		metrics.ObserveRequestDuration("put", time.Since(start).Seconds()*1000)
 	}()
-	bytes, err := g.retriever.retrieve(key)
+	if !g.mayExist(key) {
+		metrics.RecordBloomFiltered()
+		return ByteView{}, fmt.Errorf("key %q: %w", key, ErrNotFound)
+	}
+
+	item, err := g.retriever.retrieve(ctx, key)
 	if err != nil {
 		metrics.RecordDatabaseMiss()
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			// Cache empty result to prevent cache penetration
-			loggerInstance.Infof("caching empty result for non-existent key %q to prevent cache penetration", key)
-			g.populateCache(key, ByteView{})
+		if isNotFound(err) {
+			g.cacheNotFound(key)
+			return ByteView{}, fmt.Errorf("key %q: %w", key, ErrNotFound)
 		}
 		return ByteView{}, fmt.Errorf("failed to retrieve key %q locally: %w", key, err)
 	} else {
 		metrics.RecordDatabaseHit()
 	}
 
-	value := ByteView{b: cloneBytes(bytes)}
+	value := ByteView{b: cloneBytes(item.Value), expireAt: g.expireAt(item), version: item.Version, loadTime: time.Since(start)}
 	g.populateCache(key, value)
 
 	return value, nil
 }
 
+// cacheNotFound remembers that key is missing from the backing store for the
+// negative ttl, to prevent cache penetration.
+func (g *Group) cacheNotFound(key string) {
+	g.mu.RLock()
+	ttl, fraction := g.negativeTTL, g.ttlJitter
+	g.mu.RUnlock()
+	if ttl <= 0 {
+		return
+	}
+	loggerInstance.Infof("caching not found for non-existent key %q to prevent cache penetration", key)
+	g.populateCache(key, ByteView{expireAt: time.Now().Add(jitter(ttl, fraction)), notFound: true})
+}
+
+// expireAt returns the expiry time for an item loaded now.
+// The item's own expiry wins over its ttl, which wins over the group ttl.
+// Ttls are jittered, see SetTTLJitter, absolute expiry times are kept.
+func (g *Group) expireAt(item Item) time.Time {
+	if !item.ExpireAt.IsZero() {
+		return item.ExpireAt
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	ttl := item.TTL
+	if ttl <= 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(jitter(ttl, g.ttlJitter))
+}
+
 // populateCache adds a key-value pair to the cache.
+// The entry of a value is kept past its expiry for as long as it may be served stale.
 func (g *Group) populateCache(key string, value ByteView) {
-	g.cache.put(key, value)
+	if revalidate, maxStale := g.staleTTL(); !value.expireAt.IsZero() && !value.notFound && max(revalidate, maxStale) > 0 {
+		value.staleUntil = value.expireAt.Add(max(revalidate, maxStale))
+	}
+	g.mainCache.put(key, value)
 }
@@ -0,0 +1,1083 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "distcache/api/groupcachepb"
+)
+
+// countingRetriever returns the key as value and counts how often it was called.
+func countingRetriever(calls *atomic.Int32) RetrieveFunc {
+	return func(ctx context.Context, key string) ([]byte, error) {
+		calls.Add(1)
+		return []byte("value-" + key), nil
+	}
+}
+
+func TestGroup_TTL(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-ttl", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-ttl")
+	g.SetTTL(50 * time.Millisecond)
+
+	v, err := g.Get(context.Background(), "k")
+	if err != nil || v.String() != "value-k" {
+		t.Fatalf("Get(k) = %q, %v; want value-k", v.String(), err)
+	}
+	if v.ExpireAt().IsZero() {
+		t.Error("loaded value should carry an expiry time")
+	}
+
+	if _, err := g.Get(context.Background(), "k"); err != nil || calls.Load() != 1 {
+		t.Fatalf("second Get should be a cache hit, retriever calls = %d", calls.Load())
+	}
+
+	// Expiry is absolute: reading the key does not extend its lifetime
+	time.Sleep(60 * time.Millisecond)
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.IsExpired() {
+		t.Fatalf("Get after expiry = %v, %v; want a fresh value", v, err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expired entry should be reloaded, retriever calls = %d, want 2", calls.Load())
+	}
+}
+
+func TestGroup_NoTTL(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-no-ttl", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-no-ttl")
+
+	v, err := g.Get(context.Background(), "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.ExpireAt().IsZero() || v.IsExpired() {
+		t.Errorf("value of a group without ttl should never expire, expireAt = %v", v.ExpireAt())
+	}
+}
+
+func TestGroup_ItemMetadata(t *testing.T) {
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	g := NewGroup("test-item", "lru", 1024, RetrieveItemFunc(func(ctx context.Context, key string) (Item, error) {
+		switch key {
+		case "ttl":
+			return Item{Value: []byte("a"), TTL: time.Minute, Version: "v1"}, nil
+		default:
+			return Item{Value: []byte("b"), TTL: time.Minute, ExpireAt: expireAt}, nil
+		}
+	}))
+	defer DestroyGroup("test-item")
+	g.SetTTL(time.Second)
+
+	v, err := g.Get(context.Background(), "ttl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version() != "v1" {
+		t.Errorf("Version() = %q, want v1", v.Version())
+	}
+	if d := time.Until(v.ExpireAt()); d < 50*time.Second || d > time.Minute {
+		t.Errorf("item ttl should win over the group ttl, expires in %v", d)
+	}
+
+	v, err = g.Get(context.Background(), "expire-at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.ExpireAt().Equal(expireAt) {
+		t.Errorf("ExpireAt() = %v, want %v", v.ExpireAt(), expireAt)
+	}
+}
+
+func TestHTTPFetcher_Metadata(t *testing.T) {
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	NewGroup("test-http-item", "lru", 1024, RetrieveItemFunc(func(ctx context.Context, key string) (Item, error) {
+		return Item{Value: []byte("value-" + key), ExpireAt: expireAt, Version: "42"}, nil
+	}))
+	defer DestroyGroup("test-http-item")
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+
+	f := &httpFetcher{baseURL: srv.URL + defaultBasePath}
+	item, err := f.Fetch(context.Background(), "test-http-item", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(item.Value) != "value-k" || item.Version != "42" || !item.ExpireAt.Equal(expireAt) {
+		t.Errorf("Fetch() = %q version %q expireAt %v, want value-k version 42 expireAt %v",
+			item.Value, item.Version, item.ExpireAt, expireAt)
+	}
+}
+
+func TestHTTPPool_ServesPeerLocally(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-http-peer", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-http-peer")
+	// With bounded loads this node may be asked for keys another node owns
+	g.RegisterServer(&remotePeer{items: map[string]Item{"k": {Value: []byte("peer-k")}}})
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+
+	f := &httpFetcher{baseURL: srv.URL + defaultBasePath}
+	item, err := f.Fetch(context.Background(), "test-http-peer", "k")
+	if err != nil || string(item.Value) != "value-k" || calls.Load() != 1 {
+		t.Errorf("Fetch(k) = %q, %v after %d retriever calls; want it loaded locally", item.Value, err, calls.Load())
+	}
+}
+
+func TestHTTPPool_Errors(t *testing.T) {
+	NewGroup("test-http-errors", "lru", 1024, RetrieveFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return nil, fmt.Errorf("no row for %s: %w", key, ErrNotFound)
+	}))
+	defer DestroyGroup("test-http-errors")
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"test-http-errors/missing", http.StatusNotFound},
+		{"no-such-group/k", http.StatusNotFound},
+		{"test-http-errors/", http.StatusBadRequest},
+	} {
+		res, err := http.Get(srv.URL + defaultBasePath + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Errorf("GET %s answered %d, want %d", tt.path, res.StatusCode, tt.want)
+		}
+	}
+
+	f := &httpFetcher{baseURL: srv.URL + defaultBasePath}
+	for _, tt := range []struct {
+		group, key string
+		want       error
+	}{
+		{"test-http-errors", "missing", ErrNotFound},
+		{"no-such-group", "k", ErrNoSuchGroup},
+		{"test-http-errors", "", ErrInvalidKey},
+	} {
+		if _, err := f.Fetch(context.Background(), tt.group, tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("Fetch(%s, %q) = %v, want %v", tt.group, tt.key, err, tt.want)
+		} else if tt.want != ErrNotFound && errors.Is(err, ErrNotFound) {
+			t.Errorf("Fetch(%s, %q) = %v, should not report a missing key", tt.group, tt.key, err)
+		}
+	}
+}
+
+func TestMetricTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		age  time.Duration
+		want time.Duration
+	}{
+		{"fresh metric", time.Second, minMetricTTL},
+		{"stable metric", 20 * time.Minute, 2 * time.Minute},
+		{"old metric", 8 * time.Hour, maxMetricTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := metricTTL(time.Now().Add(-tt.age))
+			if got < tt.want-time.Second || got > tt.want+time.Second {
+				t.Errorf("metricTTL(now-%v) = %v, want %v", tt.age, got, tt.want)
+			}
+		})
+	}
+}
+
+// remotePeer is a Picker that owns every key and records the writes it receives.
+// Fetch fails with err if set.
+type remotePeer struct {
+	mu      sync.Mutex
+	items   map[string]Item
+	deleted []string
+	err     error
+	fetches int
+}
+
+func (p *remotePeer) Pick(key string) (Fetcher, bool) { return p, true }
+
+func (p *remotePeer) PickReplicas(key string) []Fetcher { return []Fetcher{p} }
+
+func (p *remotePeer) Fetch(ctx context.Context, group string, key string) (Item, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetches++
+	if p.err != nil {
+		return Item{}, p.err
+	}
+	return p.items[key], nil
+}
+
+func (p *remotePeer) Set(ctx context.Context, group string, key string, item Item) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items[key] = item
+	return nil
+}
+
+func (p *remotePeer) Delete(ctx context.Context, group string, key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deleted = append(p.deleted, key)
+	return nil
+}
+
+func TestGroup_SharedLoadDeadlines(t *testing.T) {
+	started := make(chan struct{})
+	var calls atomic.Int32
+	g := NewGroup("test-shared-load", "lru", 1024, RetrieveFunc(func(ctx context.Context, key string) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return []byte("value-" + key), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+	defer DestroyGroup("test-shared-load")
+
+	short, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	shortErr := make(chan error, 1)
+	go func() {
+		_, err := g.Get(short, "k")
+		shortErr <- err
+	}()
+	<-started
+
+	// Joins the load started by the caller with the short deadline
+	v, err := g.Get(context.Background(), "k")
+	if err != nil || v.String() != "value-k" {
+		t.Fatalf("Get(k) without deadline = %q, %v; want value-k", v.String(), err)
+	}
+	if err := <-shortErr; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get(k) with a 5ms deadline = %v, want context.DeadlineExceeded", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("retriever calls = %d, want 1 shared load", calls.Load())
+	}
+}
+
+func TestGroup_SetRemove(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-set", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-set")
+
+	if _, err := g.Get(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set must win over the result the flight group still holds
+	if err := g.Set(context.Background(), "k", []byte("new"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	v, err := g.Get(context.Background(), "k")
+	if err != nil || v.String() != "new" {
+		t.Fatalf("Get after Set = %q, %v; want new", v.String(), err)
+	}
+	if d := time.Until(v.ExpireAt()); d <= 0 || d > time.Minute {
+		t.Errorf("Set value expires in %v, want within a minute", d)
+	}
+
+	if err := g.Remove(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "value-k" {
+		t.Fatalf("Get after Remove = %q, %v; want value-k", v.String(), err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("retriever calls = %d, want 2", calls.Load())
+	}
+
+	if err := g.Set(context.Background(), "", []byte("x"), 0); err == nil {
+		t.Error("Set with an empty key should fail")
+	}
+}
+
+func TestGroup_SetRemoveRoutesToOwner(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-set-peer", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-set-peer")
+
+	// A stale copy on this node must not survive the remote write
+	g.populateCache("k", ByteView{b: []byte("stale")})
+
+	peer := &remotePeer{items: make(map[string]Item)}
+	g.RegisterServer(peer)
+
+	if err := g.Set(context.Background(), "k", []byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := peer.items["k"]; string(got.Value) != "new" || !got.ExpireAt.IsZero() {
+		t.Errorf("owner received %q expiring at %v, want new without expiry", got.Value, got.ExpireAt)
+	}
+	if _, ok := g.mainCache.get("k"); ok {
+		t.Error("local copy should be purged after a remote Set")
+	}
+
+	if err := g.Remove(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	if len(peer.deleted) != 1 || peer.deleted[0] != "k" {
+		t.Errorf("owner deletes = %v, want [k]", peer.deleted)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("retriever calls = %d, want 0", calls.Load())
+	}
+}
+
+func TestHTTPFetcher_SetDelete(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-http-set", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-http-set")
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+
+	f := &httpFetcher{baseURL: srv.URL + defaultBasePath}
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := f.Set(context.Background(), "test-http-set", "k", Item{Value: []byte("new"), ExpireAt: expireAt}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := g.Get(context.Background(), "k")
+	if err != nil || v.String() != "new" || !v.ExpireAt().Equal(expireAt) {
+		t.Fatalf("Get after remote Set = %q expiring at %v, %v; want new expiring at %v", v.String(), v.ExpireAt(), err, expireAt)
+	}
+
+	if err := f.Delete(context.Background(), "test-http-set", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "value-k" {
+		t.Fatalf("Get after remote Delete = %q, %v; want value-k", v.String(), err)
+	}
+}
+
+// batchRetriever returns the key as value for keys not starting with "missing"
+// and counts how often it was called.
+func batchRetriever(calls *atomic.Int32) RetrieveMultiFunc {
+	return func(ctx context.Context, keys []string) (map[string]Item, error) {
+		calls.Add(1)
+		items := make(map[string]Item, len(keys))
+		for _, key := range keys {
+			if !strings.HasPrefix(key, "missing") {
+				items[key] = Item{Value: []byte("value-" + key)}
+			}
+		}
+		return items, nil
+	}
+}
+
+// pickFunc is an adapter to allow the use of ordinary functions as Pickers.
+type pickFunc func(key string) (Fetcher, bool)
+
+func (f pickFunc) Pick(key string) (Fetcher, bool) { return f(key) }
+
+func (f pickFunc) PickReplicas(key string) []Fetcher {
+	if peer, ok := f(key); ok {
+		return []Fetcher{peer}
+	}
+	return nil
+}
+
+func TestGroup_HotCache(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-hot", "lru", 64*1024, countingRetriever(&calls))
+	defer DestroyGroup("test-hot")
+
+	if err := g.SetHotCache(1, 0); err == nil {
+		t.Error("SetHotCache with a share of 1 should fail")
+	}
+	if err := g.SetHotCache(0.25, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	g.hotAdmission = newHotAdmission(1) // sample every fetch
+
+	peer := &remotePeer{items: map[string]Item{"k": {Value: []byte("peer-k")}}}
+	g.RegisterServer(peer)
+
+	// The first fetch is not enough to keep a copy, the second one is
+	for i := 1; i <= 3; i++ {
+		g.flight.ForceEvict("k")
+		if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "peer-k" {
+			t.Fatalf("Get(k) = %q, %v; want peer-k", v.String(), err)
+		}
+	}
+	if peer.fetches != 2 {
+		t.Errorf("peer fetches = %d, want 2 before the key is served from the hot cache", peer.fetches)
+	}
+	v, ok := g.hotCache.lookup("k")
+	if !ok || v.ExpireAt().After(time.Now().Add(time.Minute)) {
+		t.Errorf("hot copy expiring at %v, want within the hot cache ttl", v.ExpireAt())
+	}
+	if _, ok := g.mainCache.lookup("k"); ok {
+		t.Error("a value owned by a peer should not enter the main cache")
+	}
+
+	// A write drops the hot copy
+	if err := g.Set(context.Background(), "k", []byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.hotCache.lookup("k"); ok {
+		t.Error("Set should drop the hot copy")
+	}
+}
+
+func TestHotAdmission_Aging(t *testing.T) {
+	a := newHotAdmission(1)
+	if a.admit("cold") {
+		t.Error("a key fetched once should not be admitted")
+	}
+	// A full aging period of other keys halves the count of cold to nothing
+	for i := 0; i < hotAgingSamples; i++ {
+		a.admit("k" + strconv.Itoa(i%100))
+	}
+	if _, ok := a.counts["cold"]; ok {
+		t.Error("aging should forget keys that cooled down")
+	}
+	if len(a.counts) > 100 {
+		t.Errorf("admission counts %d keys, want at most the 100 sampled", len(a.counts))
+	}
+}
+
+func TestGroup_StaleTTL(t *testing.T) {
+	var (
+		calls atomic.Int32
+		fail  atomic.Bool
+	)
+	g := NewGroup("test-stale", "lru", 64*1024, RetrieveItemFunc(func(ctx context.Context, key string) (Item, error) {
+		if fail.Load() {
+			return Item{}, errors.New("database down")
+		}
+		n := calls.Add(1)
+		return Item{Value: []byte("v" + strconv.Itoa(int(n))), TTL: 50 * time.Millisecond}, nil
+	}))
+	defer DestroyGroup("test-stale")
+	g.SetStaleTTL(100*time.Millisecond, time.Hour)
+
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "v1" || v.Stale() {
+		t.Fatalf("Get(k) = %q stale %v, %v; want fresh v1", v.String(), v.Stale(), err)
+	}
+
+	// Past the soft ttl the stale value is returned at once and refreshed in the background
+	time.Sleep(60 * time.Millisecond)
+	v, err := g.Get(context.Background(), "k")
+	if err != nil || v.String() != "v1" || !v.Stale() {
+		t.Fatalf("Get(k) past the soft ttl = %q stale %v, %v; want stale v1", v.String(), v.Stale(), err)
+	}
+	if resp := newGetResponse(v); !resp.GetStale() || !newItem(resp).Stale {
+		t.Error("a stale value should be flagged to peers")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, ok := g.mainCache.lookup("k"); ok && v.String() == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale value was not refreshed in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Past the hard ttl a failing retriever still gets the stale value served
+	fail.Store(true)
+	time.Sleep(160 * time.Millisecond)
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "v2" || !v.Stale() {
+		t.Fatalf("Get(k) with a failing retriever = %q stale %v, %v; want stale v2", v.String(), v.Stale(), err)
+	}
+
+	// but not beyond the max staleness
+	g.SetStaleTTL(100*time.Millisecond, 0)
+	if v, err := g.Get(context.Background(), "k"); err == nil {
+		t.Errorf("Get(k) past the max staleness = %q, want the retriever error", v.String())
+	}
+}
+
+func TestGroup_GetMultiStale(t *testing.T) {
+	var (
+		calls atomic.Int32
+		fail  atomic.Bool
+	)
+	g := NewGroup("test-multi-stale", "lru", 64*1024, RetrieveItemFunc(func(ctx context.Context, key string) (Item, error) {
+		if fail.Load() {
+			return Item{}, errors.New("database down")
+		}
+		n := calls.Add(1)
+		return Item{Value: []byte(key + strconv.Itoa(int(n))), TTL: 50 * time.Millisecond}, nil
+	}))
+	defer DestroyGroup("test-multi-stale")
+	g.SetStaleTTL(100*time.Millisecond, time.Hour)
+
+	if _, err := g.GetMulti(context.Background(), []string{"k"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the soft ttl the stale value is returned at once and refreshed in the background
+	time.Sleep(60 * time.Millisecond)
+	values, err := g.GetMulti(context.Background(), []string{"k"})
+	if v := values["k"]; err != nil || v.String() != "k1" || !v.Stale() {
+		t.Fatalf("GetMulti(k) past the soft ttl = %q stale %v, %v; want stale k1", v.String(), v.Stale(), err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, ok := g.mainCache.lookup("k"); ok && v.String() == "k2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale value was not refreshed in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Past the hard ttl a failing retriever still gets the stale value served,
+	// a key never loaded is reported missing with the retriever error
+	fail.Store(true)
+	time.Sleep(160 * time.Millisecond)
+	values, err = g.GetMulti(context.Background(), []string{"k", "other"})
+	if v := values["k"]; err == nil || v.String() != "k2" || !v.Stale() {
+		t.Fatalf("GetMulti(k, other) with a failing retriever = %q stale %v, %v; want stale k2 and an error", v.String(), v.Stale(), err)
+	}
+	if _, ok := values["other"]; ok {
+		t.Error("a key that failed to load should be missing from the result")
+	}
+	if values, err := g.GetMulti(context.Background(), []string{"k"}); err != nil || !values["k"].Stale() {
+		t.Errorf("GetMulti(k) with a failing retriever = %v, %v; want the stale value and no error", values, err)
+	}
+
+	// but not beyond the max staleness
+	g.SetStaleTTL(100*time.Millisecond, 0)
+	if values, err := g.GetMulti(context.Background(), []string{"k"}); err == nil || len(values) != 0 {
+		t.Errorf("GetMulti(k) past the max staleness = %v, %v; want the retriever error", values, err)
+	}
+}
+
+func TestGroup_EarlyRefresh(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-early", "lru", 64*1024, RetrieveItemFunc(func(ctx context.Context, key string) (Item, error) {
+		n := calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return Item{Value: []byte("v" + strconv.Itoa(int(n))), TTL: time.Hour}, nil
+	}))
+	defer DestroyGroup("test-early")
+
+	if _, err := g.Get(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := g.mainCache.lookup("k")
+	if v.loadTime < 10*time.Millisecond {
+		t.Fatalf("load time = %v, want the time the retriever took", v.loadTime)
+	}
+
+	// An hour from expiry a 10ms load is not refreshed early, unless beta is huge
+	g.SetEarlyRefresh(1)
+	if g.refreshEarly(v) {
+		t.Error("an entry far from its expiry should not be refreshed early")
+	}
+	if g.refreshEarly(ByteView{expireAt: time.Now().Add(time.Millisecond)}) {
+		t.Error("an entry without load time should not be refreshed early")
+	}
+	g.SetEarlyRefresh(1e9)
+	if v, err := g.Get(context.Background(), "k"); err != nil || v.String() != "v1" {
+		t.Fatalf("Get(k) = %q, %v; want v1 served while refreshing", v.String(), err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, ok := g.mainCache.lookup("k"); ok && v.String() == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry was not refreshed early")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if got := jitter(time.Minute, 0); got != time.Minute {
+		t.Errorf("jitter without fraction = %v, want 1m", got)
+	}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		got := jitter(time.Minute, 0.1)
+		if got < 54*time.Second || got > 66*time.Second {
+			t.Fatalf("jitter(1m, 0.1) = %v, want within 10%%", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("jitter should spread the ttls")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	b := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		b.add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !b.mayContain("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d was added but is reported missing", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if b.mayContain("other" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("%d false positives out of 10000, want about 1%%", falsePositives)
+	}
+}
+
+func TestGroup_NegativeCache(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-negative", "lru", 64*1024, RetrieveMultiFunc(func(ctx context.Context, keys []string) (map[string]Item, error) {
+		calls.Add(1)
+		items := make(map[string]Item)
+		for _, key := range keys {
+			if key != "missing" {
+				items[key] = Item{Value: []byte("value-" + key)}
+			}
+		}
+		return items, nil
+	}))
+	defer DestroyGroup("test-negative")
+
+	for i := 0; i < 2; i++ {
+		if _, err := g.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get(missing) = %v, want ErrNotFound", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("retriever called %d times, want 1 for a key cached as not found", calls.Load())
+	}
+	values, err := g.GetMulti(context.Background(), []string{"missing", "a"})
+	if err != nil || len(values) != 1 || values["a"].String() != "value-a" {
+		t.Errorf("GetMulti(missing, a) = %v, %v; want only a", values, err)
+	}
+	if len(g.mainCache.entries()) != 1 {
+		t.Error("keys cached as not found should not be handed off or snapshotted")
+	}
+
+	// Without negative caching every lookup asks the retriever
+	g.SetNegativeTTL(0)
+	g.purge("missing")
+	calls.Store(0)
+	for i := 0; i < 2; i++ {
+		g.flight.ForceEvict("missing")
+		if _, err := g.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get(missing) = %v, want ErrNotFound", err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("retriever called %d times, want 2 without negative caching", calls.Load())
+	}
+}
+
+func TestGroup_BloomFilter(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-bloom", "lru", 64*1024, countingRetriever(&calls))
+	defer DestroyGroup("test-bloom")
+
+	listed := []string{"a", "b"}
+	lister := ListKeysFunc(func() ([]string, error) { return listed, nil })
+	if err := g.SetBloomFilter(lister, 0, 0); err == nil {
+		t.Error("SetBloomFilter with a false positive rate of 0 should fail")
+	}
+	if err := g.SetBloomFilter(lister, 0.001, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := g.Get(context.Background(), "a"); err != nil || v.String() != "value-a" {
+		t.Fatalf("Get(a) = %q, %v; want value-a", v.String(), err)
+	}
+	if _, err := g.Get(context.Background(), "zzz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(zzz) = %v, want ErrNotFound", err)
+	}
+	if values, _ := g.GetMulti(context.Background(), []string{"b", "yyy"}); len(values) != 1 {
+		t.Errorf("GetMulti(b, yyy) = %v, want only b", values)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("retriever called %d times, want 2: keys missing from the filter are not looked up", calls.Load())
+	}
+
+	// Keys written through the cache or listed by a rebuild pass the filter
+	if err := g.Set(context.Background(), "new", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !g.mayExist("new") {
+		t.Error("a key written with Set should pass the filter")
+	}
+	listed = append(listed, "inserted")
+	if err := g.filter.rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	if !g.mayExist("inserted") {
+		t.Error("a key inserted behind the cache should pass the rebuilt filter")
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	h := newHeavyHitters(3)
+
+	// Three hot keys in a long tail of keys requested once
+	want := map[string]int64{"hot-a": 300, "hot-b": 200, "hot-c": 100}
+	for i := 0; i < 300; i++ {
+		for key, n := range want {
+			if int64(i) < n {
+				h.observe(key)
+			}
+		}
+		h.observe("tail-" + strconv.Itoa(i))
+	}
+
+	top := h.top(0)
+	if len(top) != 3 || top[0].Key != "hot-a" || top[1].Key != "hot-b" || top[2].Key != "hot-c" {
+		t.Fatalf("top(0) = %+v, want hot-a, hot-b, hot-c", top)
+	}
+	for _, kc := range top {
+		if kc.Count < want[kc.Key] || kc.Count-kc.Error > want[kc.Key] {
+			t.Errorf("%s counted %d with error %d, want a bound of its %d requests", kc.Key, kc.Count, kc.Error, want[kc.Key])
+		}
+	}
+	if len(h.counters) > 3*topKCounterFactor {
+		t.Errorf("monitoring %d keys, want at most %d", len(h.counters), 3*topKCounterFactor)
+	}
+	if top := h.top(1); len(top) != 1 || top[0].Key != "hot-a" {
+		t.Errorf("top(1) = %+v, want hot-a", top)
+	}
+}
+
+func TestGroup_HotKeyHook(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-hotkeys", "lru", 64*1024, countingRetriever(&calls))
+	defer DestroyGroup("test-hotkeys")
+	g.SetTopK(2)
+
+	var hot []string
+	g.SetHotKeyHook(5, func(group, key string, count int64) {
+		if group != "test-hotkeys" || count != 5 {
+			t.Errorf("hook(%s, %s, %d), want group test-hotkeys at 5 requests", group, key, count)
+		}
+		hot = append(hot, key)
+	})
+
+	for i := 0; i < 10; i++ {
+		g.Get(context.Background(), "a")
+		if i < 4 {
+			g.Get(context.Background(), "b")
+		}
+	}
+	if _, err := g.GetMulti(context.Background(), []string{"b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(hot) != 2 || hot[0] != "a" || hot[1] != "b" {
+		t.Errorf("hook called for %v, want [a b] once each", hot)
+	}
+
+	top := g.TopKeys(0)
+	if len(top) != 2 || top[0] != (KeyCount{Key: "a", Count: 10}) || top[1] != (KeyCount{Key: "b", Count: 5}) {
+		t.Errorf("TopKeys(0) = %+v, want a: 10, b: 5", top)
+	}
+
+	rec := httptest.NewRecorder()
+	HotKeysHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/hotkeys?group=test-hotkeys&n=1", nil))
+	var got map[string][]KeyCount
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if keys := got["test-hotkeys"]; len(got) != 1 || len(keys) != 1 || keys[0].Key != "a" {
+		t.Errorf("hot keys handler answered %+v, want a of test-hotkeys only", got)
+	}
+
+	rec = httptest.NewRecorder()
+	HotKeysHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/hotkeys?group=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("hot keys of a missing group answered %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestGroup_Snapshot(t *testing.T) {
+	var calls atomic.Int32
+	path := filepath.Join(t.TempDir(), "test-snapshot.snap")
+	g := NewGroup("test-snapshot", "lru", 64*1024, countingRetriever(&calls))
+	g.SetSnapshot(path, 0)
+	if n, err := g.LoadSnapshot(); n != 0 || err != nil {
+		t.Fatalf("LoadSnapshot() without a file = %d, %v; want 0, nil", n, err)
+	}
+
+	expireAt := time.Now().Add(time.Hour).Round(0)
+	g.populateCache("a", ByteView{b: []byte("va"), version: "v1"})
+	g.populateCache("b", ByteView{b: []byte("vb")})
+	g.populateCache("c", ByteView{b: []byte("vc"), expireAt: expireAt})
+	g.Get(context.Background(), "a") // most recently used
+	if n, err := g.SaveSnapshot(); n != 3 || err != nil {
+		t.Fatalf("SaveSnapshot() = %d, %v; want 3 entries", n, err)
+	}
+	DestroyGroup("test-snapshot")
+
+	// A restarted node reloads the keys it still owns, in the same access order
+	g = NewGroup("test-snapshot", "lru", 64*1024, countingRetriever(&calls))
+	defer DestroyGroup("test-snapshot")
+	g.SetSnapshot(path, 0)
+	g.RegisterServer(pickFunc(func(key string) (Fetcher, bool) {
+		if key == "b" {
+			return &remotePeer{}, true
+		}
+		return nil, false
+	}))
+	if n, err := g.LoadSnapshot(); n != 2 || err != nil {
+		t.Fatalf("LoadSnapshot() = %d, %v; want the 2 owned entries", n, err)
+	}
+	entries := g.mainCache.entries()
+	if len(entries) != 2 || entries[0].Key != "a" || entries[1].Key != "c" {
+		t.Fatalf("reloaded entries = %v, want a then c", entries)
+	}
+	if a := entries[0].Value.(ByteView); a.String() != "va" || a.Version() != "v1" {
+		t.Errorf("reloaded a = %q version %q, want va version v1", a.String(), a.Version())
+	}
+	if c := entries[1].Value.(ByteView); !c.ExpireAt().Equal(expireAt) {
+		t.Errorf("reloaded c expires at %v, want %v", c.ExpireAt(), expireAt)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("retriever called %d times, want 0", calls.Load())
+	}
+
+	// Expired entries are dropped
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, []snapshotEntry{
+		{key: "old", item: Item{Value: []byte("x"), ExpireAt: time.Now().Add(-time.Second)}},
+		{key: "new", item: Item{Value: []byte("y")}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := g.LoadSnapshot(); n != 1 || err != nil || g.mainCache.contains("old") {
+		t.Errorf("LoadSnapshot() = %d, %v; want the expired entry dropped", n, err)
+	}
+
+	// A corrupted snapshot is rejected as a whole
+	data := buf.Bytes()
+	data[len(snapshotMagic)+8] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.LoadSnapshot(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("LoadSnapshot() of a corrupted file = %v, want a checksum error", err)
+	}
+}
+
+// replicaPicker is a Picker routing every key to owner, or keeping it local if
+// owner is nil, with the replicas of every key held by replicas.
+type replicaPicker struct {
+	owner    Fetcher
+	replicas []Fetcher
+}
+
+func (p replicaPicker) Pick(key string) (Fetcher, bool) { return p.owner, p.owner != nil }
+
+func (p replicaPicker) PickReplicas(key string) []Fetcher { return p.replicas }
+
+func TestGroup_ReplicaFallback(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-replica", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-replica")
+
+	owner := &remotePeer{err: errors.New("peer down")}
+	replica := &remotePeer{items: map[string]Item{"k": {Value: []byte("replica-k")}}}
+	g.RegisterServer(replicaPicker{owner: owner, replicas: []Fetcher{owner, replica}})
+
+	v, err := g.Get(context.Background(), "k")
+	if err != nil || v.String() != "replica-k" {
+		t.Fatalf("Get(k) = %q, %v; want the value of the replica", v.String(), err)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("retriever calls = %d, want 0 while a replica is up", calls.Load())
+	}
+
+	// The retriever is the last resort once every replica failed
+	replica.err = errors.New("peer down")
+	if v, err := g.Get(context.Background(), "other"); err != nil || v.String() != "value-other" {
+		t.Fatalf("Get(other) = %q, %v; want value-other", v.String(), err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("retriever calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestGroup_WriteOnFill(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-write-on-fill", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-write-on-fill")
+
+	replica := &remotePeer{items: make(map[string]Item)}
+	g.RegisterServer(replicaPicker{replicas: []Fetcher{replica}})
+	g.SetWriteOnFill(true)
+
+	if _, err := g.Get(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	// Replicas are filled in the background
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if item, _ := replica.Fetch(context.Background(), "test-write-on-fill", "k"); string(item.Value) == "value-k" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the loaded value was not pushed to the replica")
+		}
+	}
+
+	if err := g.Set(context.Background(), "k", []byte("new"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := replica.Fetch(context.Background(), "test-write-on-fill", "k"); string(item.Value) != "new" {
+		t.Errorf("replica holds %q after Set, want new", item.Value)
+	}
+
+	// Without write-on-fill the replicas drop their copy instead
+	g.SetWriteOnFill(false)
+	if err := g.Set(context.Background(), "k", []byte("newer"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(replica.deleted) != 1 || replica.deleted[0] != "k" {
+		t.Errorf("replica deletes = %v, want [k]", replica.deleted)
+	}
+}
+
+// batchPeer is a MultiFetcher serving "peer-<key>" for every key, or failing with err.
+type batchPeer struct {
+	remotePeer
+	batches [][]string
+	err     error
+}
+
+func (p *batchPeer) FetchMulti(ctx context.Context, group string, keys []string) (map[string]Item, error) {
+	p.batches = append(p.batches, keys)
+	if p.err != nil {
+		return nil, p.err
+	}
+	items := make(map[string]Item, len(keys))
+	for _, key := range keys {
+		items[key] = Item{Value: []byte("peer-" + key)}
+	}
+	return items, nil
+}
+
+func TestGroup_GetMulti(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-multi", "lru", 1024, batchRetriever(&calls))
+	defer DestroyGroup("test-multi")
+
+	if _, err := g.Get(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := g.GetMulti(context.Background(), []string{"a", "b", "c", "b", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values["b"].String() != "value-b" || values["c"].String() != "value-c" {
+		t.Errorf("GetMulti() = %v, want a, b and c", values)
+	}
+	// One call for Get(a), one batch for b, c and missing
+	if calls.Load() != 2 {
+		t.Errorf("retriever calls = %d, want 2", calls.Load())
+	}
+
+	if _, err := g.GetMulti(context.Background(), []string{"b", "c", "missing"}); err != nil || calls.Load() != 2 {
+		t.Errorf("second GetMulti should be served from cache, retriever calls = %d, err = %v", calls.Load(), err)
+	}
+
+	if _, err := g.GetMulti(context.Background(), []string{"a", ""}); err == nil {
+		t.Error("GetMulti with an empty key should fail")
+	}
+}
+
+func TestGroup_GetMultiGroupsByPeer(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-multi-peer", "lru", 1024, batchRetriever(&calls))
+	defer DestroyGroup("test-multi-peer")
+
+	up := &batchPeer{}
+	down := &batchPeer{err: errors.New("peer down")}
+	g.RegisterServer(pickFunc(func(key string) (Fetcher, bool) {
+		switch key[0] {
+		case 'p':
+			return up, true
+		case 'd':
+			return down, true
+		}
+		return nil, false
+	}))
+
+	values, err := g.GetMulti(context.Background(), []string{"p1", "l1", "p2", "d1", "l2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"p1": "peer-p1", "p2": "peer-p2", "l1": "value-l1", "l2": "value-l2", "d1": "value-d1"}
+	for key, v := range want {
+		if values[key].String() != v {
+			t.Errorf("GetMulti()[%s] = %q, want %q", key, values[key].String(), v)
+		}
+	}
+
+	if len(up.batches) != 1 || len(up.batches[0]) != 2 {
+		t.Errorf("peer batches = %v, want one batch of p1 and p2", up.batches)
+	}
+	// Local keys and the keys of the failed peer are loaded in one batch
+	if calls.Load() != 1 {
+		t.Errorf("retriever calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestServer_GetMulti(t *testing.T) {
+	var calls atomic.Int32
+	NewGroup("test-multi-rpc", "lru", 1024, batchRetriever(&calls))
+	defer DestroyGroup("test-multi-rpc")
+
+	s, err := NewServer("127.0.0.1:9999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := s.GetMulti(context.Background(), &pb.GetMultiRequest{Group: "test-multi-rpc", Keys: []string{"a", "b", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items := resp.GetItems(); len(items) != 2 || string(items["a"].GetValue()) != "value-a" {
+		t.Errorf("GetMulti() items = %v, want a and b", items)
+	}
+	if notFound := resp.GetNotFound(); !slices.Equal(notFound, []string{"missing"}) {
+		t.Errorf("GetMulti() not found = %v, want missing", notFound)
+	}
+	if _, err := s.GetMulti(context.Background(), &pb.GetMultiRequest{Group: "no-such-group", Keys: []string{"a"}}); err == nil {
+		t.Error("GetMulti on an unknown group should fail")
+	}
+}
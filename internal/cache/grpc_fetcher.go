@@ -2,68 +2,290 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"sync"
 
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	pb "distcache/api/groupcachepb"
+	"distcache/internal/metrics"
 	"distcache/pkg/etcd/discovery"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/keepalive"
 )
 
-var _ Fetcher = (*Client)(nil)
+var (
+	_ Fetcher      = (*Client)(nil)
+	_ MultiFetcher = (*Client)(nil)
+)
+
+// Keepalive settings of peer connections. The server enforcement policy in
+// setupGRPCServer must allow pings at least this frequent.
+const (
+	keepaliveTime    = 10 * time.Second // ping an idle connection after this long
+	keepaliveTimeout = 3 * time.Second  // close the connection if a ping is not acked in time
+)
+
+// defaultCallTimeout bounds calls to a peer made without a deadline.
+const defaultCallTimeout = 1 * time.Second
+
+var errClientClosed = errors.New("client is closed")
 
+// Client talks to a single peer over one long-lived gRPC connection.
+// The connection is dialed on first use and redialed if it was shut down,
+// transient failures are retried by gRPC itself.
 type Client struct {
 	serviceName string
-	conn        *clientv3.Client
+	etcdCli     *clientv3.Client // shared etcd handle, owned by the caller of NewClient
+	conn        *grpc.ClientConn
+	closed      bool
 	mu          sync.RWMutex // 保护连接状态
+	inflight    atomic.Int64 // calls to the peer not yet answered
 }
 
-func NewClient(serviceName string) *Client {
-	cli, err := clientv3.NewFromURL("http://localhost:2379")
-	if err != nil {
-		return nil
+// NewClient creates a client for serviceName, either "GroupCache/addr" to talk
+// to one peer directly or a bare service name resolved through etcdCli.
+// The etcd handle is shared between clients and is not closed by Close.
+func NewClient(serviceName string, etcdCli *clientv3.Client) (*Client, error) {
+	if serviceName == "" {
+		return nil, fmt.Errorf("service name is required")
+	}
+	if etcdCli == nil && !strings.Contains(serviceName, "/") {
+		return nil, fmt.Errorf("an etcd client is required to resolve service %s", serviceName)
 	}
 	return &Client{
 		serviceName: serviceName,
-		conn:        cli,
+		etcdCli:     etcdCli,
+	}, nil
+}
+
+// Fetch gets the corresponding cache value and its metadata from remote peer.
+// The status code of a failed call is mapped back to ErrNotFound, ErrInvalidKey,
+// ErrNoSuchGroup or ErrPeerUnavailable.
+func (c *Client) Fetch(ctx context.Context, group string, key string) (Item, error) {
+	var resp *pb.GetResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) (err error) {
+		resp, err = grpcClient.Get(ctx, &pb.GetRequest{
+			Group: group,
+			Key:   key,
+		})
+		return err
+	})
+	if err != nil {
+		return Item{}, fmt.Errorf("could not get %s/%s from peer %s: %w", group, key, c.serviceName, fromStatus(err))
 	}
+
+	return newItem(resp), nil
 }
 
-// Fetch gets the corresponding cache value from remote peer
-func (c *Client) Fetch(group string, key string) ([]byte, error) {
-	// Discover services and obtain connection to services
-	conn, err := discovery.Discovery(c.conn, c.serviceName)
+// FetchMulti gets the values of many keys from remote peer in one call
+func (c *Client) FetchMulti(ctx context.Context, group string, keys []string) (map[string]Item, error) {
+	var resp *pb.GetMultiResponse
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) (err error) {
+		resp, err = grpcClient.GetMulti(ctx, &pb.GetMultiRequest{
+			Group: group,
+			Keys:  keys,
+		})
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not get %d keys of %s from peer %s: %w", len(keys), group, c.serviceName, fromStatus(err))
+	}
+
+	items := make(map[string]Item, len(resp.GetItems())+len(resp.GetNotFound()))
+	for key, r := range resp.GetItems() {
+		items[key] = newItem(r)
+	}
+	for _, key := range resp.GetNotFound() {
+		items[key] = Item{NotFound: true}
 	}
-	defer conn.Close()
+	return items, nil
+}
 
-	grpcClient := pb.NewGroupCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+// Set stores the value on the remote peer owning the key
+func (c *Client) Set(ctx context.Context, group string, key string, item Item) error {
+	req := &pb.SetRequest{
+		Group:   group,
+		Key:     key,
+		Value:   item.Value,
+		Version: item.Version,
+	}
+	if !item.ExpireAt.IsZero() {
+		req.ExpireAt = item.ExpireAt.UnixMilli()
+	}
 
-	start := time.Now()
-	resp, err := grpcClient.Get(ctx, &pb.GetRequest{
-		Group: group,
-		Key:   key,
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) error {
+		_, err := grpcClient.Set(ctx, req)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not set %s/%s on peer %s: %w", group, key, c.serviceName, fromStatus(err))
+	}
+	return nil
+}
+
+// Delete removes the key from the remote peer owning it
+func (c *Client) Delete(ctx context.Context, group string, key string) error {
+	err := c.call(ctx, func(ctx context.Context, grpcClient pb.GroupCacheClient) error {
+		_, err := grpcClient.Delete(ctx, &pb.DeleteRequest{
+			Group: group,
+			Key:   key,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete %s/%s on peer %s: %w", group, key, c.serviceName, fromStatus(err))
+	}
+	return nil
+}
+
+// Handoff streams entries to the peer, which became the owner of their keys.
+// It returns how many of them the peer stored and the entries the peer holds
+// afterwards, stored or already cached there.
+func (c *Client) Handoff(entries []handoffEntry) (int, []handoffEntry, error) {
+	var resp *pb.HandoffResponse
+	err := c.callTimeout(context.Background(), handoffTimeout, func(ctx context.Context, grpcClient pb.GroupCacheClient) error {
+		stream, err := grpcClient.Handoff(ctx)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			req := &pb.HandoffEntry{
+				Group:   e.group,
+				Key:     e.key,
+				Value:   e.item.Value,
+				Version: e.item.Version,
+			}
+			if !e.item.ExpireAt.IsZero() {
+				req.ExpireAt = e.item.ExpireAt.UnixMilli()
+			}
+			if err := stream.Send(req); err != nil {
+				return err
+			}
+		}
+		resp, err = stream.CloseAndRecv()
+		return err
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not get %s/%s from peer %s", group, key, c.serviceName)
+		return 0, nil, fmt.Errorf("could not hand %d entries off to peer %s: %w", len(entries), c.serviceName, fromStatus(err))
+	}
+
+	held := make([]handoffEntry, 0, len(resp.GetHeld()))
+	for _, pos := range resp.GetHeld() {
+		if pos >= 0 && pos < int64(len(entries)) {
+			held = append(held, entries[pos])
+		}
 	}
+	return int(resp.GetAccepted()), held, nil
+}
+
+// newItem converts a wire response into an Item
+func newItem(resp *pb.GetResponse) Item {
+	item := Item{Value: resp.GetValue(), Version: resp.GetVersion(), Stale: resp.GetStale()}
+	if ms := resp.GetExpireAt(); ms > 0 {
+		item.ExpireAt = time.UnixMilli(ms)
+	}
+	return item
+}
+
+// call runs fn on the pooled connection to the peer within the deadline of ctx,
+// or a one second timeout if ctx has none
+func (c *Client) call(ctx context.Context, fn func(ctx context.Context, grpcClient pb.GroupCacheClient) error) error {
+	return c.callTimeout(ctx, defaultCallTimeout, fn)
+}
 
+// callTimeout runs fn on the pooled connection to the peer within the deadline
+// of ctx, or the given timeout if ctx has none. gRPC sends the deadline along,
+// so that the peer gives up when the caller does.
+func (c *Client) callTimeout(ctx context.Context, timeout time.Duration, fn func(ctx context.Context, grpcClient pb.GroupCacheClient) error) error {
+	conn, err := c.getConn()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// The in-flight calls are the load of the peer seen by the bounded-load hash ring
+	metrics.UpdatePeerLoad(c.serviceName, c.inflight.Add(1))
+	defer func() {
+		load := c.inflight.Add(-1)
+		c.mu.RLock()
+		closed := c.closed
+		c.mu.RUnlock()
+		// A call outliving Close must not bring back the series of a departed peer
+		if closed {
+			metrics.DeletePeerLoad(c.serviceName)
+			return
+		}
+		metrics.UpdatePeerLoad(c.serviceName, load)
+	}()
+
+	start := time.Now()
+	err = fn(ctx, pb.NewGroupCacheClient(conn))
 	loggerInstance.Debugf("the duration of this grpc Call is: %v ms", time.Since(start).Milliseconds())
+	return err
+}
 
-	return resp.Value, nil
+// getConn returns the connection to the peer, dialing it if there is none yet
+// or the previous one was shut down.
+func (c *Client) getConn() (*grpc.ClientConn, error) {
+	c.mu.RLock()
+	conn, closed := c.conn, c.closed
+	c.mu.RUnlock()
+	if closed {
+		return nil, errClientClosed
+	}
+	if conn != nil && conn.GetState() != connectivity.Shutdown {
+		return conn, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errClientClosed
+	}
+	if c.conn != nil && c.conn.GetState() != connectivity.Shutdown {
+		return c.conn, nil
+	}
+
+	// Discover services and obtain connection to services
+	conn, err := discovery.Discovery(c.etcdCli, c.serviceName,
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
 }
 
+// Close closes the connection to the peer.
+// Calls on a closed client fail with errClientClosed.
 func (c *Client) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.conn != nil {
-		return c.conn.Close()
+	if c.closed {
+		return nil
 	}
-	return nil
+	c.closed = true
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
 }
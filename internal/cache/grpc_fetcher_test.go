@@ -0,0 +1,535 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"distcache/pkg/registry"
+)
+
+// startPeer serves the gRPC cache API on a random local port and returns its address.
+func startPeer(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+
+	s, err := NewServer(lis.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := s.setupGRPCServer()
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestClient_ReusesConnection(t *testing.T) {
+	var calls atomic.Int32
+	NewGroup("test-client", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-client")
+
+	c, err := NewClient("GroupCache/"+startPeer(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	item, err := c.Fetch(context.Background(), "test-client", "a")
+	if err != nil || string(item.Value) != "value-a" {
+		t.Fatalf("Fetch(a) = %q, %v; want value-a", item.Value, err)
+	}
+	conn := c.conn
+
+	if _, err := c.Fetch(context.Background(), "test-client", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if c.conn != conn {
+		t.Error("second Fetch should reuse the pooled connection")
+	}
+
+	// A connection shut down underneath the client is redialed lazily
+	conn.Close()
+	if _, err := c.Fetch(context.Background(), "test-client", "c"); err != nil {
+		t.Fatalf("Fetch after connection shutdown: %v", err)
+	}
+	if c.conn == conn {
+		t.Error("a shut down connection should be replaced")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(context.Background(), "test-client", "a", Item{}); !errors.Is(err, errClientClosed) {
+		t.Errorf("Set on a closed client = %v, want errClientClosed", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+}
+
+// groupFetcher fetches the keys of any group from group on the peer of Client.
+type groupFetcher struct {
+	*Client
+	group string
+}
+
+func (f groupFetcher) Fetch(ctx context.Context, group string, key string) (Item, error) {
+	return f.Client.Fetch(ctx, f.group, key)
+}
+
+func (f groupFetcher) FetchMulti(ctx context.Context, group string, keys []string) (map[string]Item, error) {
+	return f.Client.FetchMulti(ctx, f.group, keys)
+}
+
+func TestClient_Errors(t *testing.T) {
+	var calls atomic.Int32
+	NewGroup("test-client-errors", "lru", 1024, RetrieveFunc(func(ctx context.Context, key string) ([]byte, error) {
+		calls.Add(1)
+		return nil, ErrNotFound
+	}))
+	defer DestroyGroup("test-client-errors")
+
+	c, err := NewClient("GroupCache/"+startPeer(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, tt := range []struct {
+		group, key string
+		want       error
+	}{
+		{"test-client-errors", "missing", ErrNotFound},
+		{"test-client-errors", "", ErrInvalidKey},
+		{"no-such-group", "k", ErrNoSuchGroup},
+	} {
+		if _, err := c.Fetch(context.Background(), tt.group, tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("Fetch(%q, %q) = %v, want %v", tt.group, tt.key, err, tt.want)
+		}
+	}
+
+	// A key the owner reports missing is not loaded locally again. The
+	// requesting group has its own name, a group asking itself would wait for
+	// its own load.
+	var localCalls atomic.Int32
+	requester := NewGroup("test-client-errors-requester", "lru", 1024, countingRetriever(&localCalls))
+	defer DestroyGroup("test-client-errors-requester")
+	owner := groupFetcher{Client: c, group: "test-client-errors"}
+	requester.RegisterServer(replicaPicker{owner: owner, replicas: []Fetcher{owner}})
+	calls.Store(0)
+	if _, err := requester.Get(context.Background(), "other"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(other) = %v, want ErrNotFound", err)
+	}
+	if calls.Load() != 1 || localCalls.Load() != 0 {
+		t.Errorf("retriever calls = %d on the owner and %d locally, want 1 and 0", calls.Load(), localCalls.Load())
+	}
+
+	c.Close()
+	if _, err := c.Fetch(context.Background(), "test-client-errors", "k"); !errors.Is(err, ErrPeerUnavailable) {
+		t.Errorf("Fetch on a closed client = %v, want ErrPeerUnavailable", err)
+	}
+}
+
+func TestClient_FetchMultiNotFound(t *testing.T) {
+	var calls, localCalls atomic.Int32
+	NewGroup("test-multi-errors", "lru", 1024, batchRetriever(&calls))
+	defer DestroyGroup("test-multi-errors")
+	requester := NewGroup("test-multi-errors-requester", "lru", 1024, batchRetriever(&localCalls))
+	defer DestroyGroup("test-multi-errors-requester")
+
+	c, err := NewClient("GroupCache/"+startPeer(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	owner := groupFetcher{Client: c, group: "test-multi-errors"}
+	requester.RegisterServer(replicaPicker{owner: owner, replicas: []Fetcher{owner}})
+
+	values, err := requester.GetMulti(context.Background(), []string{"a", "missing"})
+	if err != nil || len(values) != 1 || values["a"].String() != "value-a" {
+		t.Fatalf("GetMulti(a, missing) = %v, %v; want only a", values, err)
+	}
+	// The key the owner reports missing is not loaded locally again
+	if calls.Load() != 1 || localCalls.Load() != 0 {
+		t.Errorf("retriever calls = %d on the owner and %d locally, want 1 and 0", calls.Load(), localCalls.Load())
+	}
+}
+
+func TestClient_Deadline(t *testing.T) {
+	timeout := 100 * time.Millisecond
+	var calls atomic.Int32
+	deadlines := make(chan time.Time, 1)
+	NewGroup("test-client-deadline", "lru", 1024, RetrieveFunc(func(ctx context.Context, key string) ([]byte, error) {
+		calls.Add(1)
+		deadline, _ := ctx.Deadline()
+		deadlines <- deadline
+		select {
+		case <-time.After(3 * timeout):
+			return []byte("value-" + key), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+	defer DestroyGroup("test-client-deadline")
+
+	c, err := NewClient("GroupCache/"+startPeer(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Fetch(ctx, "test-client-deadline", "k"); !errors.Is(err, ErrPeerUnavailable) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch past the deadline = %v, want ErrPeerUnavailable and context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 3*timeout {
+		t.Errorf("Fetch returned after %v, want about %v", elapsed, timeout)
+	}
+
+	select {
+	case deadline := <-deadlines:
+		if deadline.IsZero() || deadline.After(start.Add(flightTimeout+timeout)) {
+			t.Errorf("retriever deadline = %v after the call, want at most %v", deadline.Sub(start), flightTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the retriever of the peer was not called")
+	}
+
+	// The load outlived the caller giving up, later callers share its result
+	item, err := c.Fetch(context.Background(), "test-client-deadline", "k")
+	if err != nil || string(item.Value) != "value-k" {
+		t.Fatalf("Fetch(k) = %q, %v; want value-k", item.Value, err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("retriever calls = %d, want 1", calls.Load())
+	}
+
+	// Writes carry the context of the caller as well
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Set(canceled, "test-client-deadline", "k", Item{Value: []byte("v")}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Set with a canceled context = %v, want context.Canceled", err)
+	}
+	if err := c.Delete(canceled, "test-client-deadline", "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Delete with a canceled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient("", nil); err == nil {
+		t.Error("NewClient without a service name should fail")
+	}
+	if _, err := NewClient("GroupCache", nil); err == nil {
+		t.Error("NewClient resolving through etcd should require an etcd client")
+	}
+	if _, err := NewClient("GroupCache/127.0.0.1:9999", nil); err != nil {
+		t.Errorf("NewClient for a direct peer address: %v", err)
+	}
+}
+
+func TestServer_PickWithoutClient(t *testing.T) {
+	s, err := NewServer("127.0.0.1:9999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.peerSelector = NewConsistentHash(defaultReplicas, nil)
+	s.peerSelector.AddNodes("127.0.0.1:10000")
+	s.clients = make(map[string]*Client)
+
+	if fetcher, ok := s.Pick("key"); ok || fetcher != nil {
+		t.Errorf("Pick() = %v, %v; a peer without client should be handled locally", fetcher, ok)
+	}
+}
+
+func TestServer_ReconstructFromRegistry(t *testing.T) {
+	reg := registry.NewMemory()
+	ctx := context.Background()
+	// A malformed address is skipped instead of bringing the node down
+	for _, addr := range []string{"127.0.0.1:9999", "127.0.0.1:10000", "127.0.0.1"} {
+		if err := reg.Register(ctx, serviceName, registry.Instance{Addr: addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := NewServer("127.0.0.1:9999", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.reconstruct()
+	if len(s.clients) != 2 || len(s.peers) != 2 || s.peerSelector.GetNode("key") == "" {
+		t.Fatalf("clients after reconstruct = %v, want both valid registered peers", s.clients)
+	}
+	departed := s.clients["127.0.0.1:10000"]
+
+	if err := reg.Deregister(ctx, serviceName, "127.0.0.1:10000"); err != nil {
+		t.Fatal(err)
+	}
+	s.reconstruct()
+	if _, ok := s.clients["127.0.0.1:10000"]; ok || len(s.clients) != 1 {
+		t.Errorf("clients after deregister = %v, want only 127.0.0.1:9999", s.clients)
+	}
+	if err := departed.Delete(context.Background(), "g", "k"); !errors.Is(err, errClientClosed) {
+		t.Errorf("Delete on the client of a departed peer = %v, want errClientClosed", err)
+	}
+	if fetcher, ok := s.Pick("key"); ok {
+		t.Errorf("Pick() = %v, a single node ring should handle every key locally", fetcher)
+	}
+}
+
+func TestServer_FollowsRegistry(t *testing.T) {
+	reg := registry.NewMemory()
+	s, err := NewServer("127.0.0.1:9999", reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetMembershipDebounce(50 * time.Millisecond)
+	s.SetPeers(nil)
+	defer func() {
+		s.mu.Lock()
+		s.cleanup()
+		s.mu.Unlock()
+	}()
+
+	waitPeers := func(want ...string) {
+		t.Helper()
+		var peers []string
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			s.mu.RLock()
+			peers = registry.Addrs(s.peers)
+			s.mu.RUnlock()
+			if slices.Equal(peers, want) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("peers = %v, want %v", peers, want)
+	}
+	waitPeers("127.0.0.1:9999")
+
+	// A rolling restart of one peer while another joins is applied as one change
+	ctx := context.Background()
+	for _, addr := range []string{"127.0.0.1:10000", "127.0.0.1:10001"} {
+		if err := reg.Register(ctx, serviceName, registry.Instance{Addr: addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reg.Deregister(ctx, serviceName, "127.0.0.1:10001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register(ctx, serviceName, registry.Instance{Addr: "127.0.0.1:10001"}); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers("127.0.0.1:10000", "127.0.0.1:10001", "127.0.0.1:9999")
+
+	if err := reg.Deregister(ctx, serviceName, "127.0.0.1:10000"); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers("127.0.0.1:10001", "127.0.0.1:9999")
+	s.mu.RLock()
+	_, ok := s.clients["127.0.0.1:10000"]
+	s.mu.RUnlock()
+	if ok {
+		t.Error("client of the departed peer should be dropped")
+	}
+}
+
+func TestServer_LoadBound(t *testing.T) {
+	s, err := NewServer("127.0.0.1:9999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLoadBound(0.25)
+	s.rebuild([]registry.Instance{{Addr: "127.0.0.1:9999"}, {Addr: "127.0.0.1:10000"}})
+	defer func() {
+		s.mu.Lock()
+		s.cleanup()
+		s.mu.Unlock()
+	}()
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := "key" + strconv.Itoa(i); s.peerSelector.GetNode(k) == "127.0.0.1:10000" {
+			key = k
+		}
+	}
+	if _, ok := s.Pick(key); !ok {
+		t.Fatalf("Pick(%q) should route to its idle owner", key)
+	}
+
+	// The requests in flight to the owner put it over its bound, keep the key local
+	s.clients["127.0.0.1:10000"].inflight.Store(10)
+	if fetcher, ok := s.Pick(key); ok {
+		t.Errorf("Pick(%q) = %v, an overloaded owner should be skipped", key, fetcher)
+	}
+}
+
+func TestServer_OwnedKeys(t *testing.T) {
+	s, err := NewServer("127.0.0.1:9999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A restarting node, its peers do not know it yet
+	s.rebuild([]registry.Instance{{Addr: "127.0.0.1:10000"}, {Addr: "127.0.0.1:10001"}})
+	defer func() {
+		s.mu.Lock()
+		s.cleanup()
+		s.mu.Unlock()
+	}()
+
+	joined := newTestSelector(t, SelectorConsistent, []string{"127.0.0.1:9999", "127.0.0.1:10000", "127.0.0.1:10001"})
+	owns, owned := s.ownedKeys(), 0
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		if want := joined.GetNode(key) == s.addr; owns(key) != want {
+			t.Errorf("owns(%q) = %v, want %v", key, !want, want)
+		}
+		if owns(key) {
+			owned++
+		}
+	}
+	if owned == 0 {
+		t.Error("a restarting node should own some keys once it joined")
+	}
+}
+
+func TestServer_PickReplicas(t *testing.T) {
+	s, err := NewServer("127.0.0.1:9999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetReplicationFactor(2)
+	s.rebuild([]registry.Instance{{Addr: "127.0.0.1:9999"}, {Addr: "127.0.0.1:10000"}, {Addr: "127.0.0.1:10001"}})
+	defer func() {
+		s.mu.Lock()
+		s.cleanup()
+		s.mu.Unlock()
+	}()
+
+	for i := 0; i < 100; i++ {
+		key := "key" + strconv.Itoa(i)
+		nodes := s.peerSelector.GetNodes(key, 2)
+		replicas := s.PickReplicas(key)
+
+		// This node is never its own replica
+		want := slices.DeleteFunc(slices.Clone(nodes), func(node string) bool { return node == s.addr })
+		if len(replicas) != len(want) {
+			t.Fatalf("PickReplicas(%q) returned %d peers, want the clients of %v", key, len(replicas), want)
+		}
+		for j, replica := range replicas {
+			if replica != s.clients[want[j]] {
+				t.Errorf("PickReplicas(%q)[%d] is not the client of %s", key, j, want[j])
+			}
+		}
+		if peer, ok := s.Pick(key); ok && replicas[0] != peer {
+			t.Errorf("PickReplicas(%q) should start with the owner Pick returns", key)
+		}
+	}
+}
+
+func TestServer_Handoff(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-handoff", "lru", 1024, countingRetriever(&calls))
+	defer DestroyGroup("test-handoff")
+	g.populateCache("fresh", ByteView{b: []byte("loaded-here")})
+
+	c, err := NewClient("GroupCache/"+startPeer(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	accepted, held, err := c.Handoff([]handoffEntry{
+		{group: "test-handoff", key: "a", item: Item{Value: []byte("warm-a"), ExpireAt: expireAt, Version: "v1"}},
+		{group: "test-handoff", key: "fresh", item: Item{Value: []byte("old")}},
+		{group: "test-handoff", key: "expired", item: Item{Value: []byte("x"), ExpireAt: time.Now().Add(-time.Second)}},
+		{group: "no-such-group", key: "a", item: Item{Value: []byte("x")}},
+	})
+	if err != nil || accepted != 1 {
+		t.Fatalf("Handoff() = %d, %v; want only a accepted", accepted, err)
+	}
+	if len(held) != 2 || held[0].key != "a" || held[1].key != "fresh" {
+		t.Errorf("Handoff() held %v, want a and the cached fresh", held)
+	}
+
+	v, err := g.Get(context.Background(), "a")
+	if err != nil || v.String() != "warm-a" || v.Version() != "v1" || !v.ExpireAt().Equal(expireAt) {
+		t.Errorf("Get(a) = %q version %q expiring at %v, %v; want the handed off value", v.String(), v.Version(), v.ExpireAt(), err)
+	}
+	if v, _ := g.Get(context.Background(), "fresh"); v.String() != "loaded-here" {
+		t.Errorf("Get(fresh) = %q, a cached key should keep its value", v.String())
+	}
+	if calls.Load() != 0 {
+		t.Errorf("retriever calls = %d, want 0", calls.Load())
+	}
+}
+
+func TestServer_Rebalance(t *testing.T) {
+	var calls atomic.Int32
+	g := NewGroup("test-rebalance", "lru", 64*1024, countingRetriever(&calls))
+	defer DestroyGroup("test-rebalance")
+
+	self, peer := "127.0.0.1:9999", startPeer(t)
+	s, err := NewServer(self, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient("GroupCache/"+peer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	from := newTestSelector(t, SelectorConsistent, []string{self})
+	to := newTestSelector(t, SelectorConsistent, []string{self, peer})
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		g.populateCache(keys[i], ByteView{b: []byte("value")})
+	}
+
+	moved := movedEntries([]*Group{g}, from, to, self, 0)
+	if len(moved) != 1 || len(moved[peer]) == 0 || len(moved[peer]) == len(keys) {
+		t.Fatalf("movedEntries() = %d entries for %d peers, want some keys moving to %s", len(moved[peer]), len(moved), peer)
+	}
+	if limited := movedEntries([]*Group{g}, from, to, self, 3); len(limited[peer]) != 3 {
+		t.Errorf("movedEntries() with limit 3 returned %d entries", len(limited[peer]))
+	}
+
+	// Keys are kept while their new owner is unreachable
+	closed, err := NewClient("GroupCache/"+peer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	if _, _, err := closed.Handoff(moved[peer]); !errors.Is(err, ErrPeerUnavailable) {
+		t.Errorf("Handoff() on a closed client = %v, want ErrPeerUnavailable", err)
+	}
+	s.rebalance(from, to, map[string]*Client{peer: closed}, 1, len(keys))
+	for _, key := range keys {
+		if !g.mainCache.contains(key) {
+			t.Fatalf("key %s dropped although its handoff failed", key)
+		}
+	}
+
+	// Keys that moved are dropped here once handed off, the others stay
+	s.rebalance(from, to, map[string]*Client{peer: client}, 1, len(keys))
+	for _, key := range keys {
+		if owned := to.GetNode(key) == self; g.mainCache.contains(key) != owned {
+			t.Errorf("after rebalance key %s cached = %v, want %v", key, !owned, owned)
+		}
+	}
+}
@@ -3,41 +3,58 @@ package cache
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "distcache/api/groupcachepb"
+	"distcache/internal/metrics"
 	"distcache/pkg/common/validate"
-	"distcache/pkg/etcd/discovery"
+	"distcache/pkg/registry"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 var _ Picker = (*Server)(nil)
 
 var (
-	defaultAddr     = "127.0.0.1:9999"
-	defaultReplicas = 50
-	serviceName     = "GroupCache"
+	defaultAddr              = "127.0.0.1:9999"
+	defaultReplicas          = 50
+	defaultDebounce          = time.Second
+	defaultReplicationFactor = 1
+	serviceName              = "GroupCache"
 )
 
 // Server provides gRPC-based peer-to-peer communication for distributed caching.
 type Server struct {
 	pb.UnimplementedGroupCacheServer
 
-	addr        string
-	isRunning   bool
-	stopSignal  chan error
-	updateChan  chan struct{}
-	mu          sync.RWMutex
-	consistHash *ConsistentMap
-	clients     map[string]*Client
+	addr         string
+	isRunning    bool
+	stopSignal   chan error
+	mu           sync.RWMutex
+	peerSelector PeerSelector
+	selector     string // key placement algorithm, see NewPeerSelector
+	clients      map[string]*Client
+	peers        []registry.Instance // instances on the hash ring, by ascending address
+	metadata     registry.Metadata   // announced when registering, e.g. the weight of this node
+	registry     registry.Registry   // where the server registers itself and finds its peers
+	debounce     time.Duration       // quiet period before membership changes are applied
+	stopWatch    context.CancelFunc
+	loadBound    float64      // epsilon of the bounded-load hash ring, 0 disables it
+	inflight     atomic.Int64 // peer requests being served, the load of this node
+	replicas     int          // replication factor, number of nodes holding each key
+	handoffLimit int          // entries of each group pushed to their new owners on Stop
 }
 
 // NewServer creates a new cache server.
-// If addr is empty, defaultAddr is used.
-func NewServer(update chan struct{}, addr string) (*Server, error) {
+// If addr is empty, defaultAddr is used. If reg is nil, the server only
+// knows itself, as with a static registry listing addr alone.
+func NewServer(addr string, reg registry.Registry) (*Server, error) {
 	if addr == "" {
 		addr = defaultAddr
 	}
@@ -46,110 +63,369 @@ func NewServer(update chan struct{}, addr string) (*Server, error) {
 		return nil, fmt.Errorf("invalid peer address %s", addr)
 	}
 
-	return &Server{addr: addr, updateChan: update}, nil
+	if reg == nil {
+		reg = registry.NewStatic([]string{addr})
+	}
+
+	return &Server{addr: addr, registry: reg, debounce: defaultDebounce, replicas: defaultReplicationFactor, handoffLimit: defaultHandoffLimit}, nil
+}
+
+// SetMetadata sets the metadata this node registers with. Its weight scales
+// the share of keys peers route to it. It must be called before Start.
+func (s *Server) SetMetadata(md registry.Metadata) {
+	s.metadata = md
+}
+
+// SetPeerSelector sets the algorithm placing keys on peers: consistent (the
+// default), rendezvous, jump or maglev. All nodes of the cluster must use the
+// same algorithm. It must be called before SetPeers.
+func (s *Server) SetPeerSelector(algorithm string) error {
+	if _, err := NewPeerSelector(algorithm); err != nil {
+		return err
+	}
+	s.selector = algorithm
+	return nil
+}
+
+// SetLoadBound enables bounded loads on the hash ring: a peer may take at most
+// (1+epsilon) times its share of the requests in flight, further keys spill over
+// to the next peer on the ring. The load of a peer is the number of requests this
+// node sent to it and has not got an answer for, the load of this node the peer
+// requests it is serving. Only the consistent peer selector supports a bound.
+// It must be called before SetPeers, 0 disables it.
+func (s *Server) SetLoadBound(epsilon float64) {
+	s.loadBound = epsilon
+}
+
+// SetReplicationFactor sets on how many nodes a key is kept: its owner and
+// the n-1 nodes following it. Reads try these replicas in turn when the owner
+// fails before falling back to the retriever. A factor below 1 means 1.
+func (s *Server) SetReplicationFactor(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replicas = max(n, 1)
+}
+
+// SetHandoffLimit sets how many of its most recently used entries of each group
+// the node pushes to the peers taking its keys over when the ring changes or
+// it stops gracefully. 0 disables the handoff.
+func (s *Server) SetHandoffLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handoffLimit = n
+}
+
+// SetMembershipDebounce sets how long the membership has to stay unchanged before
+// the hash ring is rebuilt, so that a rolling restart of N nodes causes one rebuild
+// instead of N. It must be called before SetPeers.
+func (s *Server) SetMembershipDebounce(d time.Duration) {
+	s.debounce = d
 }
 
 // Get handles gRPC requests to fetch values from the cache.
+// The deadline of the requesting peer, carried by ctx, bounds the load.
 func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
 	group, key := req.GetGroup(), req.GetKey()
 	resp := &pb.GetResponse{}
 
 	loggerInstance.Infof("[Server %s] Received RPC request - group: %s, key: %s", s.addr, group, key)
 
-	if key == "" || group == "" {
-		return resp, fmt.Errorf("key and group name are required")
+	g, err := lookupGroup(group, key)
+	if err != nil {
+		return resp, toStatus(err)
+	}
+
+	s.inflight.Add(1)
+	defer s.inflight.Add(-1)
+
+	value, err := g.getForPeer(ctx, key)
+	if err != nil {
+		return resp, toStatus(err)
 	}
 
+	return newGetResponse(value), nil
+}
+
+// GetMulti handles gRPC requests to fetch many values of a group at once.
+// Keys that could not be loaded are left out of the response, keys missing
+// from the backing store are listed as not found.
+func (s *Server) GetMulti(ctx context.Context, req *pb.GetMultiRequest) (*pb.GetMultiResponse, error) {
+	group, keys := req.GetGroup(), req.GetKeys()
+	resp := &pb.GetMultiResponse{}
+
+	loggerInstance.Infof("[Server %s] Received GetMulti RPC request - group: %s, keys: %d", s.addr, group, len(keys))
+
+	if len(keys) == 0 {
+		return resp, toStatus(fmt.Errorf("%w: keys are required", ErrInvalidKey))
+	}
+	g, err := lookupGroup(group, keys[0])
+	if err != nil {
+		return resp, toStatus(err)
+	}
+
+	s.inflight.Add(1)
+	defer s.inflight.Add(-1)
+
+	values, err := g.getMultiForPeer(ctx, keys)
+	if err != nil {
+		if len(values) == 0 {
+			return resp, toStatus(err)
+		}
+		loggerInstance.Warnf("[Server %s] GetMulti partially failed: %v", s.addr, err)
+	}
+
+	resp.Items = make(map[string]*pb.GetResponse, len(values))
+	for key, value := range values {
+		if value.notFound {
+			resp.NotFound = append(resp.NotFound, key)
+			continue
+		}
+		resp.Items[key] = newGetResponse(value)
+	}
+	return resp, nil
+}
+
+// lookupGroup returns the group a request is for, or the error answering it
+// when the group or the key is missing.
+func lookupGroup(group, key string) (*Group, error) {
+	if key == "" {
+		return nil, fmt.Errorf("%w: key is required", ErrInvalidKey)
+	}
 	g := GetGroup(group)
 	if g == nil {
-		return resp, fmt.Errorf("no such group: %s", group)
+		return nil, fmt.Errorf("%w: %q", ErrNoSuchGroup, group)
 	}
+	return g, nil
+}
+
+// newGetResponse converts a cached value into its wire representation.
+func newGetResponse(value ByteView) *pb.GetResponse {
+	resp := &pb.GetResponse{Value: value.Bytes(), Version: value.Version(), Stale: value.Stale()}
+	if expireAt := value.ExpireAt(); !expireAt.IsZero() {
+		resp.ExpireAt = expireAt.UnixMilli()
+	}
+	return resp
+}
 
-	value, err := g.Get(key)
+// Set handles gRPC requests to store a value on the node owning the key.
+func (s *Server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
+	group, key := req.GetGroup(), req.GetKey()
+	resp := &pb.SetResponse{}
+
+	loggerInstance.Infof("[Server %s] Received Set RPC request - group: %s, key: %s", s.addr, group, key)
+
+	g, err := lookupGroup(group, key)
 	if err != nil {
-		return resp, err
+		return resp, toStatus(err)
 	}
 
-	resp.Value = value.Bytes()
+	item := Item{Value: req.GetValue(), Version: req.GetVersion()}
+	if ms := req.GetExpireAt(); ms > 0 {
+		item.ExpireAt = time.UnixMilli(ms)
+	}
+	g.setLocally(key, item)
 	return resp, nil
 }
 
-// SetPeers configures each remote host IP to the Server
-func (s *Server) SetPeers(peersAddrs []string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if len(peersAddrs) == 0 {
-		peersAddrs = []string{s.addr}
+// Handoff handles a stream of entries whose keys moved to this node, sent by
+// their previous owner. Keys already cached here keep their value, it was
+// loaded after the ring changed and is at least as fresh. The response tells
+// the sender which entries this node holds, so that it only drops those.
+func (s *Server) Handoff(stream pb.GroupCache_HandoffServer) error {
+	var received, accepted int64
+	var held []int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		pos := received
+		received++
+
+		g := GetGroup(req.GetGroup())
+		if g == nil || req.GetKey() == "" {
+			continue
+		}
+		item := Item{Value: req.GetValue(), Version: req.GetVersion()}
+		if ms := req.GetExpireAt(); ms > 0 {
+			item.ExpireAt = time.UnixMilli(ms)
+		}
+		if g.acceptHandoff(req.GetKey(), item) {
+			accepted++
+			held = append(held, pos)
+		} else if g.mainCache.contains(req.GetKey()) {
+			held = append(held, pos)
+		}
 	}
 
-	s.consistHash = NewConsistentHash(defaultReplicas, nil)
-	s.consistHash.AddNodes(peersAddrs...)
-	s.clients = make(map[string]*Client)
+	loggerInstance.Infof("[Server %s] Received handoff of %d entries, %d accepted", s.addr, received, accepted)
+	return stream.SendAndClose(&pb.HandoffResponse{Accepted: accepted, Held: held})
+}
 
-	for _, peersAddr := range peersAddrs {
-		if !validate.ValidPeerAddr(peersAddr) {
-			s.mu.Unlock()
-			panic(fmt.Sprintf("[peer %s] invalid address format, it should be x.x.x.x:port", peersAddr))
-		}
-		s.clients[peersAddr] = NewClient("GroupCache/" + peersAddr)
+// Delete handles gRPC requests to remove a key from the node owning it.
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	group, key := req.GetGroup(), req.GetKey()
+	resp := &pb.DeleteResponse{}
+
+	loggerInstance.Infof("[Server %s] Received Delete RPC request - group: %s, key: %s", s.addr, group, key)
+
+	g, err := lookupGroup(group, key)
+	if err != nil {
+		return resp, toStatus(err)
 	}
 
+	g.purge(key)
+	return resp, nil
+}
+
+// SetPeers configures each remote host IP to the Server and follows the
+// registry from then on, applying the peers joining and leaving in batches.
+// Each peer owns a share of the keys proportional to its weight. peers is
+// expected to be listed from the registry: the peers registered since are
+// applied once the watch starts.
+func (s *Server) SetPeers(peers []registry.Instance) {
+	known := peers
+	if len(peers) == 0 {
+		peers = []registry.Instance{{Addr: s.addr, Metadata: s.metadata}}
+	}
+	s.rebuild(peers)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopWatch != nil {
+		return // already watching
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := s.registry.Watch(ctx, serviceName, known)
+	if err != nil {
+		cancel()
+		loggerInstance.Errorf("failed to watch peers of %s: %v", serviceName, err)
+		return
+	}
+	s.stopWatch = cancel
+
 	go func() {
-		for {
-			select {
-			case <-s.updateChan:
-				loggerInstance.Infof("SetPeers: Received update signal over update channel, reconstructing peer configuration")
-				s.reconstruct()
-			case <-s.stopSignal:
-				if err := s.Stop(); err != nil {
-					loggerInstance.Errorf("Failed to stop server: %v", err)
-				}
-				return
-			default:
-				time.Sleep(2 * time.Second)
-			}
+		for change := range registry.Debounce(ctx, changes, s.debounce) {
+			loggerInstance.Infof("SetPeers: peers joined %v, left %v, reconstructing peer configuration", change.Added, change.Removed)
+			s.applyChange(change)
 		}
 	}()
 }
 
+// applyChange adds and removes peers from the current hash ring.
+func (s *Server) applyChange(change registry.Change) {
+	s.mu.RLock()
+	peers := make(map[string]registry.Metadata, len(s.peers)+len(change.Added))
+	for _, peer := range s.peers {
+		peers[peer.Addr] = peer.Metadata
+	}
+	s.mu.RUnlock()
+
+	for _, peer := range change.Added {
+		peers[peer.Addr] = peer.Metadata
+	}
+	for _, addr := range change.Removed {
+		delete(peers, addr)
+	}
+
+	serviceList := make([]registry.Instance, 0, len(peers))
+	for addr, md := range peers {
+		serviceList = append(serviceList, registry.Instance{Addr: addr, Metadata: md})
+	}
+	s.rebuild(serviceList)
+}
+
+// reconstruct rebuilds the hash ring from the full list of registered peers.
 func (s *Server) reconstruct() {
-	serviceList, err := discovery.ListServicePeers("GroupCache")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	serviceList, err := s.registry.List(ctx, serviceName)
 	if err != nil {
+		loggerInstance.Errorf("failed to list peers of %s: %v", serviceName, err)
 		return
 	}
+	s.rebuild(serviceList)
+}
+
+// rebuild replaces the hash ring with one made of serviceList,
+// reusing the clients of peers that stay and closing those of peers that left.
+// Peers with a malformed address, e.g. a typo in a static or file registry,
+// are logged and left out.
+func (s *Server) rebuild(serviceList []registry.Instance) {
+	serviceList = slices.DeleteFunc(slices.Clone(serviceList), func(peer registry.Instance) bool {
+		if validate.ValidPeerAddr(peer.Addr) {
+			return false
+		}
+		loggerInstance.Errorf("[peer %s] invalid address format, expect x.x.x.x:port, ignoring it", peer.Addr)
+		return true
+	})
+	slices.SortFunc(serviceList, func(a, b registry.Instance) int { return strings.Compare(a.Addr, b.Addr) })
 
 	// 创建新的 map 和 hash 环
 	newClients := make(map[string]*Client)
-	newHash := NewConsistentHash(defaultReplicas, nil)
-	newHash.AddNodes(serviceList...)
+	newHash, err := NewPeerSelector(s.selector)
+	if err != nil {
+		// SetPeerSelector only accepts known algorithms
+		panic(err)
+	}
+	for _, peer := range serviceList {
+		newHash.AddWeightedNode(peer.Addr, peer.Weight)
+	}
+	if bounded, ok := newHash.(loadBounder); ok && s.loadBound > 0 {
+		// newClients is never modified once the ring is in use
+		bounded.SetLoadBound(s.loadBound, func(node string) int64 {
+			if node == s.addr {
+				return s.inflight.Load()
+			}
+			if client, ok := newClients[node]; ok {
+				return client.inflight.Load()
+			}
+			return 0
+		})
+	} else if s.loadBound > 0 {
+		loggerInstance.Warnf("peer selector %q does not support a load bound, ignoring it", s.selector)
+	}
 
 	// 复用现有的有效连接
 	s.mu.RLock()
-	for _, peerAddr := range serviceList {
-		if !validate.ValidPeerAddr(peerAddr) {
-			s.mu.RUnlock()
-			panic(fmt.Sprintf("[peer %s] invalid address format, expect x.x.x.x:port", peerAddr))
-		}
-
+	for _, peer := range serviceList {
+		peerAddr := peer.Addr
 		// 尝试复用现有连接
 		if client, exists := s.clients[peerAddr]; exists {
 			newClients[peerAddr] = client
+		} else if client, err := NewClient(serviceName+"/"+peerAddr, nil); err == nil {
+			newClients[peerAddr] = client
 		} else {
-			newClients[peerAddr] = NewClient("GroupCache/" + peerAddr)
+			loggerInstance.Errorf("[peer %s] failed to create client: %v", peerAddr, err)
 		}
 	}
 	s.mu.RUnlock()
 
 	// 原子替换
 	s.mu.Lock()
-	oldClients := s.clients
+	oldClients, oldHash := s.clients, s.peerSelector
 	s.clients = newClients
-	s.consistHash = newHash
+	s.peerSelector = newHash
+	s.peers = serviceList
+	running, replicas, limit := s.isRunning, s.replicas, s.handoffLimit
 	s.mu.Unlock()
 
+	// Warm the new owners of the keys that moved away from this node
+	if running && oldHash != nil && limit > 0 {
+		go s.rebalance(oldHash, newHash, newClients, replicas, limit)
+	}
+
+	// Peers that left the ring no longer need their pooled connection,
+	// nor their series of the in-flight gauge
 	for addr, client := range oldClients {
 		if _, exists := newClients[addr]; !exists {
-			client.Close()
+			if err := client.Close(); err != nil {
+				loggerInstance.Warnf("failed to close client of departed peer %s: %v", addr, err)
+			}
+			metrics.DeletePeerLoad(client.serviceName)
 		}
 	}
 
@@ -164,7 +440,10 @@ func (s *Server) Pick(key string) (Fetcher, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	peerAddr := s.consistHash.GetNode(key)
+	var peerAddr string
+	if s.peerSelector != nil {
+		peerAddr = s.peerSelector.GetNode(key)
+	}
 	if peerAddr == "" {
 		loggerInstance.Warnf("hash ring not initialized yet, handling key %s locally", key)
 		return nil, false
@@ -175,8 +454,38 @@ func (s *Server) Pick(key string) (Fetcher, bool) {
 		return nil, false
 	}
 
+	client, ok := s.clients[peerAddr]
+	if !ok {
+		// Never hand out a nil client, handle the key locally instead
+		loggerInstance.Warnf("no client for peer %s, handling key %s locally", peerAddr, key)
+		return nil, false
+	}
+
 	loggerInstance.Debugf("key %s is mapped to remote peer %s", key, peerAddr)
-	return s.clients[peerAddr], true
+	return client, true
+}
+
+// PickReplicas returns the clients of the peers holding a replica of key,
+// its owner first. Peers without a client are skipped.
+func (s *Server) PickReplicas(key string) []Fetcher {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.peerSelector == nil {
+		return nil
+	}
+
+	nodes := s.peerSelector.GetNodes(key, s.replicas)
+	fetchers := make([]Fetcher, 0, len(nodes))
+	for _, node := range nodes {
+		if node == s.addr {
+			continue
+		}
+		if client, ok := s.clients[node]; ok {
+			fetchers = append(fetchers, client)
+		}
+	}
+	return fetchers
 }
 
 // Start initializes and starts the gRPC server.
@@ -241,7 +550,11 @@ func (s *Server) setupListener() (net.Listener, error) {
 }
 
 func (s *Server) setupGRPCServer() *grpc.Server {
-	grpcServer := grpc.NewServer()
+	// Peers ping idle pooled connections every keepaliveTime, allow it
+	grpcServer := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             keepaliveTime / 2,
+		PermitWithoutStream: true,
+	}))
 	pb.RegisterGroupCacheServer(grpcServer, s)
 	return grpcServer
 }
@@ -253,7 +566,8 @@ func (s *Server) registerService(lis net.Listener, errChan chan error) error {
 		}
 	}()
 
-	err := discovery.Register(serviceName, s.addr, s.stopSignal)
+	ctx := context.Background()
+	err := s.registry.Register(ctx, serviceName, registry.Instance{Addr: s.addr, Metadata: s.metadata})
 	if err != nil {
 		loggerInstance.Errorf("failed to register service: %v", err)
 		errChan <- err
@@ -262,6 +576,10 @@ func (s *Server) registerService(lis net.Listener, errChan chan error) error {
 
 	// Wait for stop signal
 	<-s.stopSignal
+	if err := s.registry.Deregister(ctx, serviceName, s.addr); err != nil {
+		loggerInstance.Errorf("failed to deregister service: %v", err)
+		return err
+	}
 	loggerInstance.Infof("service %s unregistered", s.addr)
 	return nil
 }
@@ -275,8 +593,11 @@ func (s *Server) serveRequests(grpcServer *grpc.Server, lis net.Listener) error
 }
 
 // Stop gracefully shuts down the server and cleans up resources.
+// Before leaving, it hands its hot entries off to the peers taking its keys over.
 // It's safe to call Stop multiple times.
 func (s *Server) Stop() error {
+	s.handoffOnStop()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -299,10 +620,20 @@ func (s *Server) Stop() error {
 }
 
 func (s *Server) cleanup() {
-	// Clear maps and help GC
-	for k := range s.clients {
-		delete(s.clients, k)
+	// Stop following the registry
+	if s.stopWatch != nil {
+		s.stopWatch()
+		s.stopWatch = nil
+	}
+
+	// Close pooled peer connections and drop the maps to help GC.
+	// The map itself is left untouched, a ring rebuild may still be reading it.
+	for k, client := range s.clients {
+		if err := client.Close(); err != nil {
+			loggerInstance.Warnf("failed to close client of peer %s: %v", k, err)
+		}
 	}
 	s.clients = nil
-	s.consistHash = nil
+	s.peerSelector = nil
+	s.peers = nil
 }
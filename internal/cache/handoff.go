@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"distcache/internal/metrics"
+	"distcache/pkg/registry"
+)
+
+const (
+	handoffTimeout      = 30 * time.Second // bounds the whole handoff stream to one peer
+	defaultHandoffLimit = 10000            // entries of each group a stopping node pushes to their new owners
+)
+
+// handoffEntry is a cached value moving to the new owner of its key.
+type handoffEntry struct {
+	group string
+	key   string
+	item  Item
+}
+
+// primaryNode returns the owner of key under sel, ignoring any load bound.
+func primaryNode(sel PeerSelector, key string) string {
+	if nodes := sel.GetNodes(key, 1); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return ""
+}
+
+// movedEntries returns the cached entries of groups that self owns under from
+// and another node owns under to, grouped by their new owner. With a limit
+// above zero, only the limit most recently used of them are taken from each group.
+func movedEntries(groups []*Group, from, to PeerSelector, self string, limit int) map[string][]handoffEntry {
+	moved := make(map[string][]handoffEntry)
+	for _, g := range groups {
+		n := 0
+		for _, e := range g.mainCache.entries() {
+			if limit > 0 && n == limit {
+				break
+			}
+			if primaryNode(from, e.Key) != self {
+				continue
+			}
+			owner := primaryNode(to, e.Key)
+			if owner == "" || owner == self {
+				continue
+			}
+			v := e.Value.(ByteView)
+			moved[owner] = append(moved[owner], handoffEntry{
+				group: g.name,
+				key:   e.Key,
+				item:  Item{Value: v.b, ExpireAt: v.expireAt, Version: v.version},
+			})
+			n++
+		}
+	}
+	return moved
+}
+
+// handoff streams the entries this node owned under from and another node owns
+// under to, to their new owners, so that the keys which moved do not turn into
+// cold misses there. It returns the entries their new owner holds afterwards,
+// grouped by new owner: none of a peer whose handoff failed.
+func (s *Server) handoff(from, to PeerSelector, clients map[string]*Client, limit int) map[string][]handoffEntry {
+	moved := movedEntries(allGroups(), from, to, s.addr, limit)
+
+	var mu sync.Mutex
+	held := make(map[string][]handoffEntry, len(moved))
+	var wg sync.WaitGroup
+	for peer, entries := range moved {
+		client, ok := clients[peer]
+		if !ok {
+			loggerInstance.Warnf("[Server %s] no client for peer %s, dropping handoff of %d entries", s.addr, peer, len(entries))
+			continue
+		}
+		wg.Add(1)
+		go func(peer string, client *Client, entries []handoffEntry) {
+			defer wg.Done()
+			accepted, kept, err := client.Handoff(entries)
+			metrics.RecordHandoff(len(entries), accepted)
+			if err != nil {
+				loggerInstance.Warnf("[Server %s] handoff to peer %s failed: %v", s.addr, peer, err)
+				return
+			}
+			loggerInstance.Infof("[Server %s] handed %d entries off to peer %s, %d accepted", s.addr, len(entries), peer, accepted)
+			mu.Lock()
+			held[peer] = kept
+			mu.Unlock()
+		}(peer, client, entries)
+	}
+	wg.Wait()
+	return held
+}
+
+// rebalance hands off the limit most recently used entries of each group whose
+// keys moved away from this node when the hash ring changed from from to to.
+// Those the new owner now holds are dropped locally if this node no longer holds
+// a replica of their key: the new owner serves them, and a copy left here would
+// miss the writes routed to it. The others expire with their ttl.
+func (s *Server) rebalance(from, to PeerSelector, clients map[string]*Client, replicas, limit int) {
+	for _, entries := range s.handoff(from, to, clients, limit) {
+		for _, e := range entries {
+			if slices.Contains(to.GetNodes(e.key, replicas), s.addr) {
+				continue
+			}
+			if g := GetGroup(e.group); g != nil {
+				g.purge(e.key)
+			}
+		}
+	}
+}
+
+// ownedKeys returns a predicate reporting whether this node owns a key on the
+// current ring. A node restarting is not on the ring of its peers until it
+// registered again, its own placement is then computed as if it had joined.
+func (s *Server) ownedKeys() func(key string) bool {
+	s.mu.RLock()
+	sel, peers := s.peerSelector, s.peers
+	s.mu.RUnlock()
+	if sel == nil {
+		return func(string) bool { return true }
+	}
+
+	if !slices.ContainsFunc(peers, func(peer registry.Instance) bool { return peer.Addr == s.addr }) {
+		// SetPeerSelector only accepts known algorithms
+		sel, _ = NewPeerSelector(s.selector)
+		for _, peer := range peers {
+			sel.AddWeightedNode(peer.Addr, peer.Weight)
+		}
+		sel.AddWeightedNode(s.addr, s.metadata.Weight)
+	}
+	return func(key string) bool { return primaryNode(sel, key) == s.addr }
+}
+
+// handoffOnStop pushes the most recently used entries this node owns to the
+// peers taking its keys over once it left. It must run before the clients are closed.
+func (s *Server) handoffOnStop() {
+	s.mu.RLock()
+	running, from, clients, peers, limit := s.isRunning, s.peerSelector, s.clients, s.peers, s.handoffLimit
+	s.mu.RUnlock()
+	if !running || from == nil || limit <= 0 {
+		return
+	}
+
+	// SetPeerSelector only accepts known algorithms
+	to, _ := NewPeerSelector(s.selector)
+	for _, peer := range peers {
+		if peer.Addr != s.addr {
+			to.AddWeightedNode(peer.Addr, peer.Weight)
+		}
+	}
+	s.handoff(from, to, clients, limit)
+}
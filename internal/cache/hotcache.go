@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"distcache/internal/metrics"
+)
+
+const (
+	defaultHotCacheTTL = 10 * time.Second // lifetime of a hot copy of a value owned by a peer
+	hotSampleRate      = 4                // one in hotSampleRate peer fetches is counted by the admission
+	hotAdmitThreshold  = 2                // sampled fetches of a key before it is admitted
+	hotAgingSamples    = 1024             // samples after which all counts are halved
+)
+
+// hotAdmission decides which values fetched from peers are worth a copy in the
+// hot cache. It counts a sample of the peer fetches of each key and admits a key
+// once it was sampled hotAdmitThreshold times, so that a copy is only kept for
+// keys fetched over and over again, not for the long tail of keys fetched once.
+// Counts are halved every hotAgingSamples samples, keys that cooled down are
+// forgotten and the counts never outgrow the keys sampled in one such period.
+type hotAdmission struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	samples int
+	rate    int // one in rate fetches is sampled, 1 samples them all
+}
+
+// newHotAdmission creates an admission sampling one in rate fetches.
+func newHotAdmission(rate int) *hotAdmission {
+	return &hotAdmission{counts: make(map[string]int), rate: max(rate, 1)}
+}
+
+// admit records a fetch of key from a peer and reports whether its value should be kept.
+func (a *hotAdmission) admit(key string) bool {
+	if a.rate > 1 && rand.IntN(a.rate) != 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.counts[key]++
+	count := a.counts[key]
+
+	if a.samples++; a.samples >= hotAgingSamples {
+		a.samples = 0
+		for k, n := range a.counts {
+			if n/2 == 0 {
+				delete(a.counts, k)
+			} else {
+				a.counts[k] = n / 2
+			}
+		}
+	}
+	return count >= hotAdmitThreshold
+}
+
+// populateHotCache keeps a copy of value, fetched from the peer owning key, in
+// the hot cache if the key is fetched often enough. The copy expires after the
+// hot cache ttl at the latest, writes on the owner are not seen by it.
+func (g *Group) populateHotCache(key string, value ByteView) {
+	if g.hotCache == nil || value.stale || !g.hotAdmission.admit(key) {
+		return
+	}
+
+	if expireAt := time.Now().Add(g.hotTTL); value.expireAt.IsZero() || value.expireAt.After(expireAt) {
+		value.expireAt = expireAt
+	}
+	g.hotCache.put(key, value)
+	metrics.RecordHotCacheAdmission()
+}
@@ -1,11 +1,14 @@
 package cache
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 var _ Fetcher = (*httpFetcher)(nil)
@@ -15,23 +18,76 @@ type httpFetcher struct {
 }
 
 // httpFetcher responsible for querying the value of key from the group cache of the specified node through http request
-func (h *httpFetcher) Fetch(group string, key string) ([]byte, error) {
-	u := fmt.Sprintf("%v%v/%v", h.baseURL, url.QueryEscape(group), url.QueryEscape(key))
-
-	res, err := http.Get(u)
+// The expiry and version of the value travel in the Expires and ETag headers,
+// error answers are reported as the sentinel errors of their status, see fromHTTPStatus.
+func (h *httpFetcher) Fetch(ctx context.Context, group string, key string) (Item, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.keyURL(group, key), nil)
+	if err != nil {
+		return Item{}, err
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return Item{}, fmt.Errorf("%w: %w", ErrPeerUnavailable, err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned: %v", res.Status)
+		return Item{}, fromHTTPStatus(res)
 	}
 
 	b, err := io.ReadAll(res.Body)
 	if err != nil {
-		return nil, fmt.Errorf("reading response body failed: %v", err)
+		return Item{}, fmt.Errorf("reading response body failed: %v", err)
+	}
+
+	item := Item{Value: b, Version: strings.Trim(res.Header.Get("ETag"), `"`), Stale: res.Header.Get("Warning") == staleWarning}
+	if expires := res.Header.Get("Expires"); expires != "" {
+		if t, err := http.ParseTime(expires); err == nil {
+			item.ExpireAt = t
+		}
+	}
+	return item, nil
+}
+
+// Set stores the value on the peer with a PUT request, the expiry travels in the Expires header.
+func (h *httpFetcher) Set(ctx context.Context, group string, key string, item Item) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.keyURL(group, key), bytes.NewReader(item.Value))
+	if err != nil {
+		return err
+	}
+	if !item.ExpireAt.IsZero() {
+		req.Header.Set("Expires", item.ExpireAt.UTC().Format(http.TimeFormat))
+	}
+	if item.Version != "" {
+		req.Header.Set("ETag", `"`+item.Version+`"`)
+	}
+	return h.do(req)
+}
+
+// Delete removes the key from the peer with a DELETE request.
+func (h *httpFetcher) Delete(ctx context.Context, group string, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, h.keyURL(group, key), nil)
+	if err != nil {
+		return err
+	}
+	return h.do(req)
+}
+
+// do sends a request that carries no response body.
+func (h *httpFetcher) do(req *http.Request) error {
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPeerUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fromHTTPStatus(res)
 	}
+	return nil
+}
 
-	return b, nil
+// keyURL returns the URL of key in group on the peer.
+func (h *httpFetcher) keyURL(group string, key string) string {
+	return fmt.Sprintf("%v%v/%v", h.baseURL, url.QueryEscape(group), url.QueryEscape(key))
 }
@@ -209,13 +209,16 @@ func (s *APIServer) handleAPIRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := s.cache.Get(key)
+	view, err := s.cache.Get(r.Context(), key)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("failed to get cache: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("failed to get cache: %v", err), httpStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
+	if view.Stale() {
+		w.Header().Set("Warning", staleWarning)
+	}
 	if _, err := w.Write(view.Bytes()); err != nil {
 		loggerInstance.Errorf("failed to write response: %v", err)
 	}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
@@ -12,6 +13,9 @@ var _ Picker = (*HTTPPool)(nil)
 const (
 	defaultBasePath = "/_ggcache/"
 	apiServerAddr   = "127.0.0.1:9999"
+
+	// staleWarning flags a response served past its expiry, as in RFC 7234
+	staleWarning = `110 - "Response is Stale"`
 )
 
 // HTTPPool implements an HTTP-based peer picker for distributed caching.
@@ -19,8 +23,10 @@ const (
 type HTTPPool struct {
 	currentServer string
 	basePath      string
-	peerSelector  *ConsistentMap
+	peerSelector  PeerSelector
+	selector      string // key placement algorithm, see NewPeerSelector
 	fetcherMap    map[string]*httpFetcher
+	replicas      int // replication factor, see Server.SetReplicationFactor
 	mu            sync.Mutex
 }
 
@@ -29,9 +35,29 @@ func NewHTTPPool(srvAddr string) *HTTPPool {
 	return &HTTPPool{
 		currentServer: srvAddr,
 		basePath:      defaultBasePath,
+		replicas:      defaultReplicationFactor,
 	}
 }
 
+// SetPeerSelector sets the algorithm placing keys on peers, see Server.SetPeerSelector.
+// It must be called before UpdatePeers.
+func (p *HTTPPool) SetPeerSelector(algorithm string) error {
+	if _, err := NewPeerSelector(algorithm); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.selector = algorithm
+	return nil
+}
+
+// SetReplicationFactor sets on how many peers a key is kept, see Server.SetReplicationFactor.
+func (p *HTTPPool) SetReplicationFactor(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replicas = max(n, 1)
+}
+
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		http.Error(w, "invalid cache endpoint", http.StatusBadRequest)
@@ -46,20 +72,39 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	groupName, key := parts[0], parts[1]
-	group := GetGroup(groupName)
-	if group == nil {
-		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+	group, err := lookupGroup(parts[0], parts[1])
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	key := parts[1]
+
+	switch r.Method {
+	case http.MethodPut:
+		p.handleSet(w, r, group, key)
+		return
+	case http.MethodDelete:
+		group.purge(key)
 		return
 	}
 
-	view, err := group.Get(key)
+	// The requesting peer already routed key here, serve it without routing it on
+	view, err := group.getForPeer(r.Context(), key)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeHTTPError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/octet-stream")
+	if version := view.Version(); version != "" {
+		w.Header().Set("ETag", `"`+version+`"`)
+	}
+	if expireAt := view.ExpireAt(); !expireAt.IsZero() {
+		w.Header().Set("Expires", expireAt.UTC().Format(http.TimeFormat))
+	}
+	if view.Stale() {
+		w.Header().Set("Warning", staleWarning)
+	}
 	if _, err := w.Write(view.Bytes()); err != nil {
 		loggerInstance.Errorf("Failed to write response: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -67,6 +112,24 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleSet stores the request body under key, honouring an Expires header.
+func (p *HTTPPool) handleSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	item := Item{Value: b, Version: strings.Trim(r.Header.Get("ETag"), `"`)}
+	if expires := r.Header.Get("Expires"); expires != "" {
+		if item.ExpireAt, err = http.ParseTime(expires); err != nil {
+			http.Error(w, "invalid Expires header: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	group.setLocally(key, item)
+}
+
 func (p *HTTPPool) Log(format string, v ...interface{}) {
 	loggerInstance.Infof("[Server %s] %s", p.currentServer, fmt.Sprintf(format, v...))
 }
@@ -77,6 +140,9 @@ func (p *HTTPPool) Pick(key string) (Fetcher, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.peerSelector == nil {
+		return nil, false
+	}
 	peerAddress := p.peerSelector.GetNode(key)
 	if peerAddress == p.currentServer {
 		// upper layer get the value of the key locally after receiving false
@@ -88,12 +154,31 @@ func (p *HTTPPool) Pick(key string) (Fetcher, bool) {
 	return p.fetcherMap[peerAddress], true
 }
 
+// PickReplicas implements the Picker interface.
+// It returns the HTTP clients of the peers holding a replica of key, its owner first.
+func (p *HTTPPool) PickReplicas(key string) []Fetcher {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.peerSelector == nil {
+		return nil
+	}
+	var fetchers []Fetcher
+	for _, peer := range p.peerSelector.GetNodes(key, p.replicas) {
+		if peer != p.currentServer {
+			fetchers = append(fetchers, p.fetcherMap[peer])
+		}
+	}
+	return fetchers
+}
+
 // UpdatePeers updates the peer list and rebuilds the consistent hash ring.
 func (p *HTTPPool) UpdatePeers(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.peerSelector = NewConsistentHash(defaultReplicas, nil)
+	// SetPeerSelector only accepts known algorithms
+	p.peerSelector, _ = NewPeerSelector(p.selector)
 	p.peerSelector.AddNodes(peers...)
 	p.fetcherMap = make(map[string]*httpFetcher, len(peers))
 
@@ -1,33 +1,134 @@
 package cache
 
+import (
+	"context"
+	"time"
+)
+
 // Picker is the interface that must be implemented to locate peers.
 // It uses consistent hashing to determine which node should handle a specific key.
 type Picker interface {
 	// Pick returns the fetcher for the peer that should handle the given key.
 	// If the key should be handled by the current node, returns (nil, false).
 	Pick(key string) (Fetcher, bool)
+
+	// PickReplicas returns the peers holding a replica of key in order of
+	// preference: the owner of key followed by its successors, up to the
+	// replication factor. The current node is left out, so for a key owned
+	// locally only the successors are returned. With a load bound, the peer
+	// Pick returns may be missing from the list.
+	PickReplicas(key string) []Fetcher
 }
 
-// Fetcher is the interface that wraps the basic Fetch method.
+// Fetcher is the interface that wraps the basic Fetch, Set and Delete methods.
 // Each distributed node must implement this interface to support peer-to-peer cache retrieval.
 type Fetcher interface {
 	// Fetch retrieves the value for key from the specified group's cache.
-	// Returns the value as bytes and any error encountered.
-	Fetch(group string, key string) ([]byte, error)
+	// Returns the value with its expiry and version, and any error encountered.
+	// The deadline of ctx, if any, bounds the request and travels to the peer.
+	Fetch(ctx context.Context, group string, key string) (Item, error)
+
+	// Set stores item under key in the specified group's cache on the peer.
+	// A zero ExpireAt lets the peer apply its group ttl.
+	// The deadline of ctx, if any, bounds the request.
+	Set(ctx context.Context, group string, key string, item Item) error
+
+	// Delete removes key from the specified group's cache on the peer.
+	// The deadline of ctx, if any, bounds the request.
+	Delete(ctx context.Context, group string, key string) error
+}
+
+// MultiFetcher is implemented by fetchers that can retrieve many keys in one round trip.
+// Group.GetMulti falls back to one Fetch per key for fetchers that do not implement it.
+type MultiFetcher interface {
+	// FetchMulti retrieves the values for keys from the specified group's cache.
+	// Keys the peer failed to load are missing from the returned map, keys
+	// missing from the backing store of the peer are mapped to an Item with
+	// NotFound set.
+	FetchMulti(ctx context.Context, group string, keys []string) (map[string]Item, error)
+}
+
+// Item is a value loaded from the backing store together with its cache metadata.
+type Item struct {
+	Value    []byte
+	TTL      time.Duration // lifetime relative to the load time, zero falls back to the group ttl
+	ExpireAt time.Time     // absolute expiry time, takes precedence over TTL
+	Version  string        // optional version or ETag of the value
+	Stale    bool          // the peer served the value past its expiry
+	NotFound bool          // the key is missing from the backing store of the peer, see MultiFetcher
 }
 
 // Retriever is the interface that wraps the basic retrieve method.
 // It provides the ability to fetch data from a backing store when cache misses occur.
 type Retriever interface {
-	// retrieve fetches data for the given key from the backing store.
-	retrieve(key string) ([]byte, error)
+	// retrieve fetches data for the given key from the backing store,
+	// giving up once ctx is done.
+	retrieve(ctx context.Context, key string) (Item, error)
 }
 
 // RetrieveFunc is an adapter to allow the use of ordinary functions as Retrievers.
 // This is a common pattern in Go that allows simple functions to satisfy an interface.
-type RetrieveFunc func(key string) ([]byte, error)
+type RetrieveFunc func(ctx context.Context, key string) ([]byte, error)
+
+// retrieve calls f(ctx, key), implementing the Retriever interface.
+// The loaded value carries no metadata, so the group ttl applies.
+func (f RetrieveFunc) retrieve(ctx context.Context, key string) (Item, error) {
+	b, err := f(ctx, key)
+	return Item{Value: b}, err
+}
+
+// RetrieveItemFunc is an adapter for loaders that also know how long
+// their data stays valid and which version of it they returned.
+type RetrieveItemFunc func(ctx context.Context, key string) (Item, error)
+
+// retrieve calls f(ctx, key), implementing the Retriever interface.
+func (f RetrieveItemFunc) retrieve(ctx context.Context, key string) (Item, error) {
+	return f(ctx, key)
+}
+
+// BatchRetriever is implemented by retrievers that can load many keys with a
+// single query against the backing store.
+type BatchRetriever interface {
+	Retriever
+
+	// retrieveMulti fetches data for the given keys from the backing store.
+	// Keys missing from the returned map do not exist in the store.
+	retrieveMulti(ctx context.Context, keys []string) (map[string]Item, error)
+}
+
+// RetrieveMultiFunc is an adapter for loaders that fetch keys in batches.
+// A single key is retrieved as a batch of one.
+type RetrieveMultiFunc func(ctx context.Context, keys []string) (map[string]Item, error)
+
+// retrieve calls f with key alone, implementing the Retriever interface.
+func (f RetrieveMultiFunc) retrieve(ctx context.Context, key string) (Item, error) {
+	items, err := f(ctx, []string{key})
+	if err != nil {
+		return Item{}, err
+	}
+	item, ok := items[key]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	return item, nil
+}
+
+// retrieveMulti calls f(ctx, keys), implementing the BatchRetriever interface.
+func (f RetrieveMultiFunc) retrieveMulti(ctx context.Context, keys []string) (map[string]Item, error) {
+	return f(ctx, keys)
+}
+
+// KeyLister is implemented by backing stores that can list their keys, to
+// build the Bloom filter of a group, see Group.SetBloomFilter.
+type KeyLister interface {
+	// listKeys returns all the keys of the backing store.
+	listKeys() ([]string, error)
+}
+
+// ListKeysFunc is an adapter to allow the use of ordinary functions as KeyListers.
+type ListKeysFunc func() ([]string, error)
 
-// retrieve calls f(key), implementing the Retriever interface.
-func (f RetrieveFunc) retrieve(key string) ([]byte, error) {
-	return f(key)
+// listKeys calls f(), implementing the KeyLister interface.
+func (f ListKeysFunc) listKeys() ([]string, error) {
+	return f()
 }
@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"sync"
+)
+
+// maxJumpBuckets bounds the number of buckets, weights too far apart to be
+// represented within it are rounded.
+const maxJumpBuckets = 1 << 16
+
+// JumpHash implements jump consistent hashing (Lamping and Veach). It needs no
+// memory besides the node list and spreads keys very evenly, but it maps keys to
+// bucket numbers: nodes are numbered in ascending address order, so a node joining
+// or leaving anywhere but at the end renumbers the nodes after it and moves their keys.
+// The lightest node gets one bucket and every other node a number of buckets
+// proportional to its weight relative to it.
+type JumpHash struct {
+	mu      sync.RWMutex
+	set     nodeSet
+	buckets []string // node of each bucket
+}
+
+// NewJumpHash creates an empty jump hash selector.
+func NewJumpHash() *JumpHash {
+	return &JumpHash{}
+}
+
+// AddNodes adds nodes of default weight.
+func (j *JumpHash) AddNodes(nodes ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	changed := false
+	for _, node := range nodes {
+		changed = j.set.add(node, 0) || changed
+	}
+	if changed {
+		j.rebuild()
+	}
+}
+
+// AddWeightedNode adds node owning a share of the keys proportional to its weight.
+func (j *JumpHash) AddWeightedNode(node string, weight int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.set.add(node, weight) {
+		j.rebuild()
+	}
+}
+
+// RemoveNode removes node.
+func (j *JumpHash) RemoveNode(node string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.set.remove(node) {
+		j.rebuild()
+	}
+}
+
+// rebuild numbers the buckets, j.mu must be held.
+func (j *JumpHash) rebuild() {
+	j.buckets = j.buckets[:0]
+	if len(j.set.nodes) == 0 {
+		return
+	}
+
+	// The weight a bucket stands for: the lightest weight, or more if the
+	// buckets would not fit in maxJumpBuckets otherwise
+	unit, total := j.set.weights[j.set.nodes[0]], 0
+	for _, node := range j.set.nodes {
+		unit = min(unit, j.set.weights[node])
+		total += j.set.weights[node]
+	}
+	unit = max(unit, (total+maxJumpBuckets-1)/maxJumpBuckets)
+
+	for _, node := range j.set.nodes {
+		count := max(1, (j.set.weights[node]+unit/2)/unit)
+		for i := 0; i < count; i++ {
+			j.buckets = append(j.buckets, node)
+		}
+	}
+}
+
+// GetNode returns the node of the bucket key jumps to.
+func (j *JumpHash) GetNode(key string) string {
+	if key == "" || j == nil {
+		return ""
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jump(hash64(key), len(j.buckets))]
+}
+
+// GetNodes returns up to n distinct nodes for key: the node of the bucket key
+// jumps to, followed by the nodes of the next buckets.
+func (j *JumpHash) GetNodes(key string, n int) []string {
+	if key == "" || j == nil || n <= 0 {
+		return nil
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if len(j.buckets) == 0 {
+		return nil
+	}
+	return distinctFrom(j.buckets, jump(hash64(key), len(j.buckets)), min(n, len(j.set.nodes)))
+}
+
+// jump returns the bucket in [0, buckets) of key.
+func jump(key uint64, buckets int) int {
+	var b, next int64 = -1, 0
+	for next < int64(buckets) {
+		b = next
+		key = key*2862933555777941757 + 1
+		next = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
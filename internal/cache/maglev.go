@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"sync"
+)
+
+// defaultMaglevTableSize is the lookup table size, a prime well above 100
+// times the expected number of nodes keeps the shares within about 1%.
+const defaultMaglevTableSize = 65537
+
+// Maglev implements Maglev hashing: every node fills the slots of a fixed size
+// lookup table in the order of its own permutation, so a key is placed with a
+// single table lookup. A node joining or leaving moves slightly more keys than
+// its share, since some slots of other nodes change hands as well.
+// The table is filled lazily by the first lookup after the nodes changed, so
+// adding the nodes of a cluster one by one fills it only once.
+type Maglev struct {
+	mu    sync.RWMutex
+	set   nodeSet
+	size  uint64
+	table []string // node of each slot
+	stale bool     // the nodes changed since the table was filled
+}
+
+// NewMaglev creates an empty Maglev selector with a lookup table of size slots,
+// which should be prime. A size of 0 or less uses the default.
+func NewMaglev(size int) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevTableSize
+	}
+	return &Maglev{size: uint64(size)}
+}
+
+// AddNodes adds nodes of default weight.
+func (m *Maglev) AddNodes(nodes ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	changed := false
+	for _, node := range nodes {
+		changed = m.set.add(node, 0) || changed
+	}
+	m.stale = m.stale || changed
+}
+
+// AddWeightedNode adds node owning a share of the keys proportional to its weight.
+func (m *Maglev) AddWeightedNode(node string, weight int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stale = m.set.add(node, weight) || m.stale
+}
+
+// RemoveNode removes node.
+func (m *Maglev) RemoveNode(node string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stale = m.set.remove(node) || m.stale
+}
+
+// rlock read-locks m, filling the lookup table first if it is stale.
+func (m *Maglev) rlock() {
+	m.mu.RLock()
+	for m.stale {
+		m.mu.RUnlock()
+		m.mu.Lock()
+		if m.stale {
+			m.populate()
+			m.stale = false
+		}
+		m.mu.Unlock()
+		m.mu.RLock()
+	}
+}
+
+// populate fills the lookup table, m.mu must be held. Nodes take turns
+// claiming the next free slot of their permutation; a node claims slots in
+// proportion to its weight relative to the heaviest node.
+func (m *Maglev) populate() {
+	nodes := m.set.nodes
+	if len(nodes) == 0 {
+		m.table = nil
+		return
+	}
+
+	offset := make([]uint64, len(nodes))
+	skip := make([]uint64, len(nodes))
+	next := make([]uint64, len(nodes))
+	credit := make([]float64, len(nodes))
+	maxWeight := 0
+	for i, node := range nodes {
+		offset[i] = hash64("offset", node) % m.size
+		skip[i] = hash64("skip", node)%(m.size-1) + 1
+		maxWeight = max(maxWeight, m.set.weights[node])
+	}
+
+	owner := make([]int, m.size)
+	for i := range owner {
+		owner[i] = -1
+	}
+	for filled := uint64(0); filled < m.size; {
+		for i, node := range nodes {
+			credit[i] += float64(m.set.weights[node]) / float64(maxWeight)
+			for ; credit[i] >= 1 && filled < m.size; credit[i]-- {
+				slot := (offset[i] + next[i]*skip[i]) % m.size
+				for owner[slot] >= 0 {
+					next[i]++
+					slot = (offset[i] + next[i]*skip[i]) % m.size
+				}
+				owner[slot] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+
+	m.table = make([]string, m.size)
+	for slot, i := range owner {
+		m.table[slot] = nodes[i]
+	}
+}
+
+// GetNode returns the node of the slot key hashes to.
+func (m *Maglev) GetNode(key string) string {
+	if key == "" || m == nil {
+		return ""
+	}
+
+	m.rlock()
+	defer m.mu.RUnlock()
+
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.table[hash64(key)%m.size]
+}
+
+// GetNodes returns up to n distinct nodes for key: the node of the slot key
+// hashes to, followed by the nodes of the next slots.
+func (m *Maglev) GetNodes(key string, n int) []string {
+	if key == "" || m == nil || n <= 0 {
+		return nil
+	}
+
+	m.rlock()
+	defer m.mu.RUnlock()
+
+	if len(m.table) == 0 {
+		return nil
+	}
+	return distinctFrom(m.table, int(hash64(key)%m.size), min(n, len(m.set.nodes)))
+}
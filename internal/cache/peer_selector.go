@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+
+	"distcache/pkg/registry"
+)
+
+var (
+	_ PeerSelector = (*ConsistentMap)(nil)
+	_ PeerSelector = (*Rendezvous)(nil)
+	_ PeerSelector = (*JumpHash)(nil)
+	_ PeerSelector = (*Maglev)(nil)
+)
+
+// PeerSelector decides which node owns a key. Every node of the cluster must
+// build its selector from the same nodes with the same algorithm, so that all
+// of them route a key to the same owner.
+type PeerSelector interface {
+	// AddNodes adds nodes of default weight.
+	AddNodes(nodes ...string)
+
+	// AddWeightedNode adds node owning a share of the keys proportional to its weight.
+	// A weight of 0 or less means registry.DefaultWeight.
+	AddWeightedNode(node string, weight int)
+
+	// RemoveNode removes node, it is a no-op for unknown nodes.
+	RemoveNode(node string)
+
+	// GetNode returns the node owning key, or "" if there are no nodes.
+	GetNode(key string) string
+
+	// GetNodes returns up to n distinct nodes for key in order of preference,
+	// which hold the replicas of key: the owner GetNode returns without a load
+	// bound, followed by its successors. With the consistent and rendezvous
+	// selectors the second node is the one taking the key over if the owner leaves.
+	GetNodes(key string, n int) []string
+}
+
+// loadBounder is implemented by selectors that can skip overloaded nodes.
+type loadBounder interface {
+	SetLoadBound(epsilon float64, load LoadFunc)
+}
+
+// Key placement algorithms accepted by NewPeerSelector.
+const (
+	SelectorConsistent = "consistent" // hash ring with virtual nodes, supports bounded loads
+	SelectorRendezvous = "rendezvous" // highest random weight
+	SelectorJump       = "jump"       // jump consistent hash
+	SelectorMaglev     = "maglev"     // Maglev lookup table
+)
+
+// NewPeerSelector creates an empty selector for the named algorithm.
+// An empty name selects the consistent hash ring.
+func NewPeerSelector(algorithm string) (PeerSelector, error) {
+	switch strings.ToLower(algorithm) {
+	case "", SelectorConsistent:
+		return NewConsistentHash(defaultReplicas, nil), nil
+	case SelectorRendezvous:
+		return NewRendezvous(), nil
+	case SelectorJump:
+		return NewJumpHash(), nil
+	case SelectorMaglev:
+		return NewMaglev(defaultMaglevTableSize), nil
+	default:
+		return nil, fmt.Errorf("unknown peer selector %q, expected consistent, rendezvous, jump or maglev", algorithm)
+	}
+}
+
+// nodeSet is the weighted node list shared by the selectors that are rebuilt
+// from all nodes on every change. Nodes are kept in ascending order so that
+// every cluster member derives the same placement.
+type nodeSet struct {
+	nodes   []string
+	weights map[string]int
+}
+
+// add inserts or reweighs node and reports whether the set changed.
+func (s *nodeSet) add(node string, weight int) bool {
+	if node == "" {
+		return false
+	}
+	if weight <= 0 {
+		weight = registry.DefaultWeight
+	}
+	if s.weights == nil {
+		s.weights = make(map[string]int)
+	}
+	old, exists := s.weights[node]
+	s.weights[node] = weight
+	if !exists {
+		i, _ := slices.BinarySearch(s.nodes, node)
+		s.nodes = slices.Insert(s.nodes, i, node)
+	}
+	return !exists || old != weight
+}
+
+// remove deletes node and reports whether it was present.
+func (s *nodeSet) remove(node string) bool {
+	if _, exists := s.weights[node]; !exists {
+		return false
+	}
+	delete(s.weights, node)
+	i, _ := slices.BinarySearch(s.nodes, node)
+	s.nodes = slices.Delete(s.nodes, i, i+1)
+	return true
+}
+
+// distinctFrom returns the first n distinct nodes of slots, starting at slot
+// start and wrapping around. slots must hold at least n distinct nodes.
+func distinctFrom(slots []string, start, n int) []string {
+	nodes := make([]string, 0, n)
+	for i := 0; i < len(slots) && len(nodes) < n; i++ {
+		if node := slots[(start+i)%len(slots)]; !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// hash64 hashes parts with FNV-1a and mixes the result,
+// FNV alone spreads inputs differing in few bytes poorly.
+func hash64(parts ...string) uint64 {
+	h := fnv.New64a()
+	for i, part := range parts {
+		if i > 0 {
+			h.Write([]byte{0}) // keep ("ab", "c") apart from ("a", "bc")
+		}
+		h.Write([]byte(part))
+	}
+	return mix64(h.Sum64())
+}
+
+// mix64 is the finalizer of SplitMix64.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
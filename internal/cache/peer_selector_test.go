@@ -0,0 +1,259 @@
+package cache
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+var selectorAlgorithms = []string{SelectorConsistent, SelectorRendezvous, SelectorJump, SelectorMaglev}
+
+// newTestSelector creates a selector of algorithm holding nodes.
+func newTestSelector(tb testing.TB, algorithm string, nodes []string) PeerSelector {
+	tb.Helper()
+	sel, err := NewPeerSelector(algorithm)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sel.AddNodes(nodes...)
+	return sel
+}
+
+// testNodes returns n node addresses in ascending order.
+func testNodes(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.0.%d:9999", 10+i)
+	}
+	return nodes
+}
+
+// placement returns the owner of each of keys keys.
+func placement(sel PeerSelector, keys int) []string {
+	owners := make([]string, keys)
+	for i := range owners {
+		owners[i] = sel.GetNode("key" + strconv.Itoa(i))
+	}
+	return owners
+}
+
+// moved returns the fraction of keys whose owner differs between before and after.
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+// keyMovement measures the keys moving when a node joins at the end of the
+// address order and when a node leaves from the middle of it.
+func keyMovement(tb testing.TB, algorithm string, nodes int, keys int) (join, leave float64) {
+	sel := newTestSelector(tb, algorithm, testNodes(nodes))
+	before := placement(sel, keys)
+
+	all := testNodes(nodes + 1)
+	sel.AddNodes(all[nodes])
+	join = moved(before, placement(sel, keys))
+
+	sel.RemoveNode(all[nodes])
+	sel.RemoveNode(all[nodes/2])
+	leave = moved(before, placement(sel, keys))
+	return join, leave
+}
+
+func TestNewPeerSelector(t *testing.T) {
+	for _, algorithm := range append(selectorAlgorithms, "", "Maglev") {
+		if _, err := NewPeerSelector(algorithm); err != nil {
+			t.Errorf("NewPeerSelector(%q) failed: %v", algorithm, err)
+		}
+	}
+	if _, err := NewPeerSelector("random"); err == nil {
+		t.Error("NewPeerSelector(random) should fail")
+	}
+}
+
+func TestPeerSelector_Placement(t *testing.T) {
+	for _, algorithm := range selectorAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			sel, _ := NewPeerSelector(algorithm)
+			if got := sel.GetNode("key"); got != "" {
+				t.Errorf("GetNode() on no nodes = %q, want empty string", got)
+			}
+
+			sel.AddWeightedNode("light", 10)
+			sel.AddWeightedNode("heavy", 30)
+			if sel.GetNode("") != "" {
+				t.Error("GetNode of an empty key should be empty")
+			}
+
+			// Every node built the same way agrees on the owner
+			other, _ := NewPeerSelector(algorithm)
+			other.AddWeightedNode("heavy", 30)
+			other.AddWeightedNode("light", 10)
+
+			const keys = 20000
+			owned := make(map[string]int)
+			for i := 0; i < keys; i++ {
+				key := "key" + strconv.Itoa(i)
+				node := sel.GetNode(key)
+				if node != other.GetNode(key) {
+					t.Fatalf("selectors built in another order disagree on %q", key)
+				}
+				owned[node]++
+			}
+
+			// The heavy node owns about three quarters of the keys
+			share := float64(owned["heavy"]) / keys
+			if math.Abs(share-0.75) > 0.1 {
+				t.Errorf("node of weight 30 owns %.2f of the keys, want about 0.75 (%v)", share, owned)
+			}
+
+			sel.RemoveNode("heavy")
+			sel.RemoveNode("unknown")
+			if got := sel.GetNode("key"); got != "light" {
+				t.Errorf("GetNode() after removal = %q, want light", got)
+			}
+		})
+	}
+}
+
+func TestPeerSelector_SmallWeights(t *testing.T) {
+	// Weights well below registry.DefaultWeight keep their ratio
+	for _, algorithm := range selectorAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			sel, _ := NewPeerSelector(algorithm)
+			sel.AddWeightedNode("light", 1)
+			sel.AddWeightedNode("heavy", 3)
+
+			const keys = 20000
+			owned := make(map[string]int)
+			for _, node := range placement(sel, keys) {
+				owned[node]++
+			}
+			if share := float64(owned["heavy"]) / keys; math.Abs(share-0.75) > 0.1 {
+				t.Errorf("node of weight 3 owns %.2f of the keys, want about 0.75 (%v)", share, owned)
+			}
+		})
+	}
+}
+
+func TestMaglev_FillsTableLazily(t *testing.T) {
+	m := NewMaglev(0)
+	for _, node := range testNodes(5) {
+		m.AddWeightedNode(node, 20)
+	}
+	if m.table != nil {
+		t.Fatal("adding nodes should not fill the table before a lookup")
+	}
+	if m.GetNode("key") == "" || len(m.table) != defaultMaglevTableSize {
+		t.Fatalf("GetNode() should fill a table of %d slots, got %d", defaultMaglevTableSize, len(m.table))
+	}
+
+	m.RemoveNode(testNodes(5)[0])
+	for i := 0; i < 1000; i++ {
+		if node := m.GetNode("key" + strconv.Itoa(i)); node == testNodes(5)[0] {
+			t.Fatalf("key%d still placed on removed node %s", i, node)
+		}
+	}
+}
+
+func TestPeerSelector_GetNodes(t *testing.T) {
+	for _, algorithm := range selectorAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			sel := newTestSelector(t, algorithm, testNodes(5))
+			if got := sel.GetNodes("", 3); got != nil {
+				t.Errorf("GetNodes of an empty key = %v, want none", got)
+			}
+			if got := sel.GetNodes("key", 10); len(got) != 5 {
+				t.Errorf("GetNodes(key, 10) = %v, want all 5 nodes", got)
+			}
+
+			for i := 0; i < 1000; i++ {
+				key := "key" + strconv.Itoa(i)
+				nodes := sel.GetNodes(key, 3)
+				if len(nodes) != 3 || nodes[0] != sel.GetNode(key) {
+					t.Fatalf("GetNodes(%q, 3) = %v, want 3 nodes starting with owner %s", key, nodes, sel.GetNode(key))
+				}
+				if nodes[0] == nodes[1] || nodes[0] == nodes[2] || nodes[1] == nodes[2] {
+					t.Fatalf("GetNodes(%q, 3) = %v, want distinct nodes", key, nodes)
+				}
+			}
+		})
+	}
+}
+
+func TestPeerSelector_GetNodesSuccessor(t *testing.T) {
+	// The second replica of a key takes it over when its owner leaves
+	for _, algorithm := range []string{SelectorConsistent, SelectorRendezvous} {
+		t.Run(algorithm, func(t *testing.T) {
+			sel := newTestSelector(t, algorithm, testNodes(5))
+			replicas := make([][]string, 1000)
+			for i := range replicas {
+				replicas[i] = sel.GetNodes("key"+strconv.Itoa(i), 2)
+			}
+
+			left := testNodes(5)[2]
+			sel.RemoveNode(left)
+			for i, nodes := range replicas {
+				if nodes[0] == left && sel.GetNode("key"+strconv.Itoa(i)) != nodes[1] {
+					t.Fatalf("key%d moved to %s, want its second replica %s", i, sel.GetNode("key"+strconv.Itoa(i)), nodes[1])
+				}
+			}
+		})
+	}
+}
+
+func TestPeerSelector_KeyMovement(t *testing.T) {
+	// Ideal movement with 5 nodes: 1/6 of the keys when the 6th joins, 1/5 when one leaves
+	tests := []struct {
+		algorithm string
+		maxJoin   float64
+		maxLeave  float64
+	}{
+		{SelectorConsistent, 0.25, 0.3},
+		{SelectorRendezvous, 0.2, 0.25},
+		{SelectorJump, 0.2, 0.7}, // leaving from the middle renumbers the nodes after it
+		{SelectorMaglev, 0.25, 0.3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			join, leave := keyMovement(t, tt.algorithm, 5, 20000)
+			t.Logf("%s: %.3f of the keys moved on join, %.3f on leave", tt.algorithm, join, leave)
+			if join > tt.maxJoin {
+				t.Errorf("join moved %.3f of the keys, want at most %.2f", join, tt.maxJoin)
+			}
+			if leave > tt.maxLeave {
+				t.Errorf("leave moved %.3f of the keys, want at most %.2f", leave, tt.maxLeave)
+			}
+		})
+	}
+}
+
+func BenchmarkPeerSelector_KeyMovement(b *testing.B) {
+	for _, algorithm := range selectorAlgorithms {
+		b.Run(algorithm, func(b *testing.B) {
+			var join, leave float64
+			for i := 0; i < b.N; i++ {
+				join, leave = keyMovement(b, algorithm, 10, 10000)
+			}
+			b.ReportMetric(join, "moved-join")
+			b.ReportMetric(leave, "moved-leave")
+		})
+	}
+}
+
+func BenchmarkPeerSelector_GetNode(b *testing.B) {
+	for _, algorithm := range selectorAlgorithms {
+		b.Run(algorithm, func(b *testing.B) {
+			sel := newTestSelector(b, algorithm, testNodes(10))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				sel.GetNode("key" + strconv.Itoa(i))
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// refreshEarly reports whether value, found in the cache and not expired yet,
+// should be reloaded before it expires. This is XFetch, from "Optimal
+// Probabilistic Cache Stampede Prevention" by Vattani et al.: a request
+// refreshes the value when
+//
+//	now - loadTime * beta * ln(rand) >= expireAt
+//
+// so the probability rises as the expiry approaches, and earlier for values
+// that take long to load. Among the requests for a key, usually a single one
+// refreshes it ahead of time, instead of all of them missing at once.
+func (g *Group) refreshEarly(value ByteView) bool {
+	if value.expireAt.IsZero() || value.loadTime <= 0 {
+		return false
+	}
+	beta := g.earlyRefreshBeta()
+	if beta <= 0 {
+		return false
+	}
+	// 1-Float64 is in (0, 1], the logarithm is finite and not positive
+	gap := time.Duration(-float64(value.loadTime) * beta * math.Log(1-rand.Float64()))
+	return !time.Now().Add(gap).Before(value.expireAt)
+}
+
+// refreshInBackground reloads key in the background, skipping the result the
+// flight group may hold for it. A key is refreshed once at a time, outliving
+// the request that triggered it.
+func (g *Group) refreshInBackground(key string, picker Picker) {
+	if _, running := g.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer g.refreshing.Delete(key)
+		g.flight.ForceEvict(key)
+		if _, err := g.load(context.Background(), key, picker); err != nil {
+			loggerInstance.Warnf("Group %s failed to refresh key %s: %v", g.name, key, err)
+		}
+	}()
+}
+
+// jitter spreads ttl uniformly over ttl ± fraction*ttl, so that entries
+// loaded together do not expire together.
+func jitter(ttl time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || ttl <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration((2*rand.Float64()-1)*fraction*float64(ttl))
+}
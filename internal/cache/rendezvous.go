@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"sync"
+)
+
+// Rendezvous implements rendezvous (highest random weight) hashing: every node
+// scores the key and the highest score wins. Adding or removing a node only
+// moves the keys it wins or won, without any virtual nodes, at the cost of
+// scoring every node on each lookup.
+type Rendezvous struct {
+	mu  sync.RWMutex
+	set nodeSet
+}
+
+// NewRendezvous creates an empty rendezvous selector.
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{}
+}
+
+// AddNodes adds nodes of default weight.
+func (r *Rendezvous) AddNodes(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		r.set.add(node, 0)
+	}
+}
+
+// AddWeightedNode adds node owning a share of the keys proportional to its weight.
+func (r *Rendezvous) AddWeightedNode(node string, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.set.add(node, weight)
+}
+
+// RemoveNode removes node.
+func (r *Rendezvous) RemoveNode(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.set.remove(node)
+}
+
+// GetNode returns the node with the highest score for key.
+func (r *Rendezvous) GetNode(key string) string {
+	if key == "" || r == nil {
+		return ""
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		best      string
+		bestScore = math.Inf(-1)
+	)
+	for _, node := range r.set.nodes {
+		if score := r.score(node, key); score > bestScore {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// GetNodes returns up to n distinct nodes for key by descending score.
+func (r *Rendezvous) GetNodes(key string, n int) []string {
+	if key == "" || r == nil || n <= 0 {
+		return nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	type scored struct {
+		node  string
+		score float64
+	}
+	ranked := make([]scored, len(r.set.nodes))
+	for i, node := range r.set.nodes {
+		ranked[i] = scored{node, r.score(node, key)}
+	}
+	slices.SortStableFunc(ranked, func(a, b scored) int { return cmp.Compare(b.score, a.score) })
+
+	nodes := make([]string, 0, min(n, len(ranked)))
+	for _, s := range ranked[:cap(nodes)] {
+		nodes = append(nodes, s.node)
+	}
+	return nodes
+}
+
+// score returns the score of node for key, r.mu must be held.
+// Weighted scores -w/ln(u), u uniform in (0,1), make a node win
+// a share of the keys proportional to its weight.
+func (r *Rendezvous) score(node, key string) float64 {
+	u := (float64(hash64(node, key)>>11) + 0.5) / (1 << 53)
+	return -float64(r.set.weights[node]) / math.Log(u)
+}
@@ -19,6 +19,9 @@ type call struct {
 	res  Result        // The result of the call
 }
 
+// flightTimeout bounds a shared call started by a caller with a shorter deadline.
+const flightTimeout = 5 * time.Second
+
 // cacheEntry represents a cached result with expiration.
 type cacheEntry struct {
 	result  Result
@@ -59,7 +62,11 @@ func NewFlightGroup(ttl time.Duration) *FlightGroup {
 // Do executes the given function if it's not already being executed.
 // If there's a duplicate call, the caller waits for the original to complete.
 // Results are cached according to the TTL.
-func (g *FlightGroup) Do(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+// The function runs detached from the cancellation of the caller starting it,
+// until its deadline or flightTimeout, whichever is later, and each caller
+// stops waiting when its own ctx is done: a caller with a short deadline does
+// not fail the others.
+func (g *FlightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
 	// Check cache first (read lock)
 	if value, ok := g.checkCache(key); ok {
 		return value.Value, value.Err
@@ -67,14 +74,10 @@ func (g *FlightGroup) Do(ctx context.Context, key string, fn func() (interface{}
 
 	// Get or create call (write lock)
 	c, created := g.createCall(key)
-	if !created {
-		return g.waitForCall(ctx, c)
+	if created {
+		go g.execute(ctx, key, fn, c)
 	}
-
-	// Execute function and store result
-	defer g.finishCall(key, c)
-
-	return g.executeAndCache(ctx, key, fn, c)
+	return g.waitForCall(ctx, c)
 }
 
 func (g *FlightGroup) checkCache(key string) (Result, bool) {
@@ -109,43 +112,29 @@ func (g *FlightGroup) waitForCall(ctx context.Context, c *call) (interface{}, er
 	}
 }
 
-func (g *FlightGroup) executeAndCache(ctx context.Context, key string, fn func() (interface{}, error), c *call) (interface{}, error) {
-	// Run the function
-	value, err := g.executeWithContext(ctx, fn)
-	result := Result{Value: value, Err: err}
-	c.res = result
+// execute runs fn for call c, caches its result if successful and completes c.
+func (g *FlightGroup) execute(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error), c *call) {
+	defer g.finishCall(key, c)
+
+	timeout := flightTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(timeout, time.Until(deadline))
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	value, err := fn(ctx)
+	c.res = Result{Value: value, Err: err}
 
 	// Cache successful results
 	if err == nil {
 		g.mu.Lock()
 		g.cache[key] = cacheEntry{
-			result:  result,
+			result:  c.res,
 			expires: time.Now().Add(g.ttl),
 		}
 		g.mu.Unlock()
 	}
-
-	return value, err
-}
-
-func (g *FlightGroup) executeWithContext(ctx context.Context, fn func() (interface{}, error)) (interface{}, error) {
-	var (
-		value interface{}
-		err   error
-		done  = make(chan struct{})
-	)
-
-	go func() {
-		value, err = fn()
-		close(done)
-	}()
-
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case <-done:
-		return value, err
-	}
 }
 
 func (g *FlightGroup) finishCall(key string, c *call) {
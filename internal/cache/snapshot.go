@@ -0,0 +1,298 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// A snapshot file holds the entries of the main cache of a group, least
+// recently used first, so that reloading them in file order restores the
+// recency of the entries:
+//
+//	magic    "DCSN"
+//	version  uint16, snapshotVersion
+//	count    uint32, number of entries
+//	entries  key, value, expiry as int64 Unix nanoseconds (0 means never), version
+//	checksum uint32, CRC-32C of all the bytes before it
+//
+// Integers are big endian, strings and values are prefixed with their length
+// as an unsigned varint.
+const (
+	snapshotMagic   = "DCSN"
+	snapshotVersion = 1
+)
+
+var snapshotTable = crc32.MakeTable(crc32.Castagnoli)
+
+// snapshotEntry is a cache entry read from a snapshot.
+type snapshotEntry struct {
+	key  string
+	item Item
+}
+
+// keyOwner is implemented by pickers that can tell which keys this node owns
+// before it joined the ring, as on a restart.
+type keyOwner interface {
+	ownedKeys() func(key string) bool
+}
+
+// SetSnapshot makes the group keep a snapshot of its main cache in the file at
+// path, written every interval and by SaveSnapshot, and reloaded by
+// LoadSnapshot, so that a restarted node does not start with a cold cache.
+// An interval of 0 or less only writes the snapshot on demand, an empty path
+// disables snapshots. It must be called before the group serves requests.
+func (g *Group) SetSnapshot(path string, interval time.Duration) {
+	g.stopSnapshots()
+	g.snapshotPath = path
+	if path == "" || interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	g.snapshotStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := g.SaveSnapshot(); err != nil {
+					loggerInstance.Warnf("Group %s failed to write its snapshot: %v", g.name, err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopSnapshots stops writing snapshots periodically.
+func (g *Group) stopSnapshots() {
+	if g.snapshotStop != nil {
+		close(g.snapshotStop)
+		g.snapshotStop = nil
+	}
+}
+
+// SaveSnapshot writes the entries of the main cache that have not expired to
+// the snapshot file and returns how many it wrote. The file is replaced
+// atomically, a crash while writing leaves the previous snapshot in place.
+func (g *Group) SaveSnapshot() (int, error) {
+	if g.snapshotPath == "" {
+		return 0, nil
+	}
+
+	entries := g.mainCache.entries()
+	slices.Reverse(entries) // least recently used first
+
+	tmp, err := os.CreateTemp(filepath.Dir(g.snapshotPath), filepath.Base(g.snapshotPath)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // fails once renamed
+
+	w := bufio.NewWriter(tmp)
+	snapshot := make([]snapshotEntry, 0, len(entries))
+	for _, e := range entries {
+		v := e.Value.(ByteView)
+		snapshot = append(snapshot, snapshotEntry{key: e.Key, item: Item{Value: v.b, ExpireAt: v.expireAt, Version: v.version}})
+	}
+	if err := writeSnapshot(w, snapshot); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), g.snapshotPath); err != nil {
+		return 0, err
+	}
+	return len(snapshot), nil
+}
+
+// LoadSnapshot fills the main cache from the snapshot file and returns how
+// many entries it loaded. Expired entries and keys this node does not own are
+// dropped. A missing snapshot file is not an error.
+func (g *Group) LoadSnapshot() (int, error) {
+	if g.snapshotPath == "" {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(g.snapshotPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	entries, err := readSnapshot(data)
+	if err != nil {
+		return 0, fmt.Errorf("snapshot %s: %w", g.snapshotPath, err)
+	}
+
+	owns := g.ownedKeys()
+	now, loaded := time.Now(), 0
+	for _, e := range entries {
+		if !e.item.ExpireAt.IsZero() && !e.item.ExpireAt.After(now) {
+			continue
+		}
+		if !owns(e.key) {
+			continue
+		}
+		g.populateCache(e.key, ByteView{b: e.item.Value, expireAt: e.item.ExpireAt, version: e.item.Version})
+		loaded++
+	}
+	return loaded, nil
+}
+
+// ownedKeys returns a predicate reporting whether this node owns a key.
+func (g *Group) ownedKeys() func(key string) bool {
+	switch p := g.server.(type) {
+	case nil:
+		return func(string) bool { return true }
+	case keyOwner:
+		return p.ownedKeys()
+	default:
+		return func(key string) bool {
+			_, remote := p.Pick(key)
+			return !remote
+		}
+	}
+}
+
+// SaveSnapshots writes the snapshot of every group that has one configured.
+func SaveSnapshots() {
+	for _, g := range allGroups() {
+		if n, err := g.SaveSnapshot(); err != nil {
+			loggerInstance.Errorf("Group %s failed to write its snapshot: %v", g.name, err)
+		} else if g.snapshotPath != "" {
+			loggerInstance.Infof("Group %s wrote %d entries to snapshot %s", g.name, n, g.snapshotPath)
+		}
+	}
+}
+
+// LoadSnapshots reloads the snapshot of every group that has one configured.
+// It must run once the peers are known, to drop the keys owned by others.
+func LoadSnapshots() {
+	for _, g := range allGroups() {
+		if n, err := g.LoadSnapshot(); err != nil {
+			loggerInstance.Errorf("Group %s failed to load its snapshot: %v", g.name, err)
+		} else if g.snapshotPath != "" {
+			loggerInstance.Infof("Group %s loaded %d entries from snapshot %s", g.name, n, g.snapshotPath)
+		}
+	}
+}
+
+// writeSnapshot writes entries to w in the snapshot format.
+func writeSnapshot(w io.Writer, entries []snapshotEntry) error {
+	crc := crc32.New(snapshotTable)
+	mw := io.MultiWriter(w, crc)
+
+	buf := append([]byte(snapshotMagic), 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(buf[4:], snapshotVersion)
+	binary.BigEndian.PutUint32(buf[6:], uint32(len(entries)))
+	if _, err := mw.Write(buf); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		var expireAt int64
+		if !e.item.ExpireAt.IsZero() {
+			expireAt = e.item.ExpireAt.UnixNano()
+		}
+		buf = appendBytes(buf[:0], []byte(e.key))
+		buf = appendBytes(buf, e.item.Value)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(expireAt))
+		buf = appendBytes(buf, []byte(e.item.Version))
+		if _, err := mw.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
+
+// readSnapshot parses a snapshot written by writeSnapshot.
+func readSnapshot(data []byte) ([]snapshotEntry, error) {
+	const headerLen = len(snapshotMagic) + 2 + 4
+	if len(data) < headerLen+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a cache snapshot")
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, snapshotTable) != sum {
+		return nil, errors.New("checksum mismatch, snapshot is corrupted")
+	}
+	if v := binary.BigEndian.Uint16(body[4:]); v != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, want %d", v, snapshotVersion)
+	}
+
+	count := binary.BigEndian.Uint32(body[6:])
+	r := bytes.NewReader(body[headerLen:])
+	entries := make([]snapshotEntry, 0, min(count, uint32(r.Len())))
+	for i := uint32(0); i < count; i++ {
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		var expireAt int64
+		if err := binary.Read(r, binary.BigEndian, &expireAt); err != nil {
+			return nil, err
+		}
+		version, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+
+		item := Item{Value: value, Version: string(version)}
+		if expireAt != 0 {
+			item.ExpireAt = time.Unix(0, expireAt)
+		}
+		entries = append(entries, snapshotEntry{key: string(key), item: item})
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after %d entries", r.Len(), count)
+	}
+	return entries, nil
+}
+
+// appendBytes appends b prefixed with its length to buf.
+func appendBytes(buf, b []byte) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(b))), b...)
+}
+
+// readBytes reads a length-prefixed byte string written by appendBytes.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
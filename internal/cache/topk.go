@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"cmp"
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultTopK       = 10     // keys reported by the heavy hitters of a group
+	topKCounterFactor = 4      // counters kept per reported key, more counters make the counts more accurate
+	topKDecayEvery    = 100000 // requests after which all counts are halved, so the top follows recent traffic
+)
+
+// KeyCount is a key with its estimated number of requests.
+// The estimate exceeds the true count by at most Error.
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+	Error int64  `json:"error"`
+}
+
+// HotKeyHook is called when the estimated requests of key in group reach the
+// threshold it was set with. It runs on the request path and must not block.
+type HotKeyHook func(group, key string, count int64)
+
+// heavyHitters finds the most requested keys of a group with the Space-Saving
+// algorithm: it monitors a fixed number of keys, and a key that is not
+// monitored replaces the one with the lowest count, inheriting that count as
+// its error bound. Any key requested more often than requests/counters is
+// guaranteed to be monitored.
+type heavyHitters struct {
+	mu        sync.Mutex
+	k         int                    // keys reported
+	counters  counterHeap            // monitored keys, lowest count first
+	index     map[string]*keyCounter // monitored keys by key
+	requests  int64                  // requests since the last decay
+	threshold int64                  // count reporting a key as hot, 0 disables it
+}
+
+// keyCounter is a monitored key of heavyHitters.
+type keyCounter struct {
+	KeyCount
+	pos int // position in the heap
+}
+
+// newHeavyHitters creates a detector reporting the k most requested keys.
+func newHeavyHitters(k int) *heavyHitters {
+	if k <= 0 {
+		k = defaultTopK
+	}
+	return &heavyHitters{k: k, index: make(map[string]*keyCounter)}
+}
+
+// observe counts a request for key. It returns the estimated count of key and
+// whether this request made it reach the hot key threshold. The threshold is
+// compared to the guaranteed count, the estimate minus its error, so that a key
+// taking the counter of another one is not reported for requests it did not get.
+func (h *heavyHitters) observe(key string) (int64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.requests++; h.requests >= topKDecayEvery {
+		h.decay()
+	}
+
+	c, ok := h.index[key]
+	switch {
+	case ok:
+		c.Count++
+		heap.Fix(&h.counters, c.pos)
+	case len(h.counters) < h.k*topKCounterFactor:
+		c = &keyCounter{KeyCount: KeyCount{Key: key, Count: 1}}
+		h.index[key] = c
+		heap.Push(&h.counters, c)
+	default:
+		// Replace the least requested key, its count bounds the error of key
+		c = h.counters[0]
+		delete(h.index, c.Key)
+		c.KeyCount = KeyCount{Key: key, Count: c.Count + 1, Error: c.Count}
+		h.index[key] = c
+		heap.Fix(&h.counters, 0)
+	}
+	return c.Count, h.threshold > 0 && c.Count-c.Error == h.threshold
+}
+
+// decay halves all counts, h.mu must be held. Halving keeps the heap order.
+func (h *heavyHitters) decay() {
+	h.requests = 0
+	for _, c := range h.counters {
+		c.Count /= 2
+		c.Error /= 2
+	}
+}
+
+// top returns the n most requested keys, most requested first. n of 0 or less means k.
+func (h *heavyHitters) top(n int) []KeyCount {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n <= 0 || n > h.k {
+		n = h.k
+	}
+	counts := make([]KeyCount, 0, len(h.counters))
+	for _, c := range h.counters {
+		if c.Count > 0 {
+			counts = append(counts, c.KeyCount)
+		}
+	}
+	slices.SortFunc(counts, func(a, b KeyCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Key, b.Key))
+	})
+	return counts[:min(n, len(counts))]
+}
+
+// setThreshold sets the count at which observe reports a key as hot.
+func (h *heavyHitters) setThreshold(threshold int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.threshold = threshold
+}
+
+// counterHeap is a min-heap of counters by count, see container/heap.
+type counterHeap []*keyCounter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+
+func (h *counterHeap) Push(x any) {
+	c := x.(*keyCounter)
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// TopKeys returns the n most requested keys of every group by group name,
+// most requested first. n of 0 or less reports the configured top K.
+func TopKeys(n int) map[string][]KeyCount {
+	top := make(map[string][]KeyCount)
+	for _, g := range allGroups() {
+		top[g.name] = g.TopKeys(n)
+	}
+	return top
+}
+
+// TopKeyCounts returns the estimated requests of the top keys of every group,
+// by group and key, in the form collected by metrics.SetTopKeysSource.
+func TopKeyCounts() map[string]map[string]int64 {
+	counts := make(map[string]map[string]int64)
+	for group, keys := range TopKeys(0) {
+		counts[group] = make(map[string]int64, len(keys))
+		for _, kc := range keys {
+			counts[group][kc.Key] = kc.Count
+		}
+	}
+	return counts
+}
+
+// HotKeysHandler serves the top keys as JSON, by group name. The optional
+// group query parameter restricts the answer to one group, n to its n most
+// requested keys.
+func HotKeysHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := 0
+		if s := r.URL.Query().Get("n"); s != "" {
+			var err error
+			if n, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "invalid n: "+s, http.StatusBadRequest)
+				return
+			}
+		}
+
+		top := TopKeys(n)
+		if name := r.URL.Query().Get("group"); name != "" {
+			keys, ok := top[name]
+			if !ok {
+				http.Error(w, "no such group: "+name, http.StatusNotFound)
+				return
+			}
+			top = map[string][]KeyCount{name: keys}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(top); err != nil {
+			loggerInstance.Errorf("Failed to write hot keys: %v", err)
+		}
+	})
+}
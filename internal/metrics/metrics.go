@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
 
 	"distcache/pkg/common/logger"
 	"github.com/prometheus/client_golang/prometheus"
@@ -85,6 +86,90 @@ var (
 		},
 	})
 
+	peerLoad = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distcache_peer_inflight_requests",
+		Help: "The number of requests sent to a peer and not yet answered",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	}, []string{"peer"})
+
+	loadRedirects = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "distcache_load_redirects_total",
+		Help: "The total number of keys routed past their owner because it was over its load bound",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	})
+
+	replicaFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "distcache_replica_fallbacks_total",
+		Help: "The total number of keys served by a replica because their owner failed",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	})
+
+	handoffEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "distcache_handoff_entries_total",
+		Help: "The total number of cache entries handed off to the new owner of their key, by outcome",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	}, []string{"result"})
+
+	hotCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "distcache_hot_cache_hits_total",
+		Help: "The total number of requests served from the hot cache of values owned by peers",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	})
+
+	hotCacheAdmissions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "distcache_hot_cache_admissions_total",
+		Help: "The total number of values fetched from peers that were copied into the hot cache",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	})
+
+	staleServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "distcache_stale_served_total",
+		Help: "The total number of values served past their expiry, by reason: revalidate or error",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	}, []string{"reason"})
+
+	earlyRefreshes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "distcache_early_refreshes_total",
+		Help: "The total number of entries refreshed in the background before they expired",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	})
+
+	bloomFiltered = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "distcache_bloom_filtered_total",
+		Help: "The total number of lookups of keys the bloom filter proved missing from the database",
+		ConstLabels: prometheus.Labels{
+			"instance": instanceName,
+		},
+	})
+
+	topKeys = &topKeysCollector{
+		desc: prometheus.NewDesc(
+			"distcache_top_key_requests",
+			"The estimated number of requests of the most requested keys of a group",
+			[]string{"group", "key"},
+			prometheus.Labels{"instance": instanceName},
+		),
+	}
+
+	// admin endpoints served next to /metrics, see HandleAdmin
+	adminHandlers = make(map[string]http.Handler)
+
 	requestDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "distcache_request_duration_seconds",
@@ -101,6 +186,48 @@ func init() {
 		hostname = "unknown"
 	}
 	instanceName = hostname
+
+	prometheus.MustRegister(topKeys)
+}
+
+// topKeysCollector exports the top keys of every group, asking its source for
+// them on each scrape so that keys dropping out of the top leave no stale series.
+type topKeysCollector struct {
+	desc   *prometheus.Desc
+	mu     sync.RWMutex
+	source func() map[string]map[string]int64
+}
+
+func (c *topKeysCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *topKeysCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	source := c.source
+	c.mu.RUnlock()
+	if source == nil {
+		return
+	}
+	for group, keys := range source() {
+		for key, count := range keys {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), group, key)
+		}
+	}
+}
+
+// SetTopKeysSource sets the function reporting the estimated requests of the
+// top keys, by group and key, exported as distcache_top_key_requests
+func SetTopKeysSource(source func() map[string]map[string]int64) {
+	topKeys.mu.Lock()
+	defer topKeys.mu.Unlock()
+	topKeys.source = source
+}
+
+// HandleAdmin registers an admin endpoint served by the metrics server,
+// it must be called before StartMetricsServer
+func HandleAdmin(pattern string, handler http.Handler) {
+	adminHandlers[pattern] = handler
 }
 
 // StartMetricsServer 启动指标收集服务器
@@ -110,6 +237,11 @@ func StartMetricsServer(port int) {
 	// register metrics endpoint
 	mux.Handle("/metrics", promhttp.Handler())
 
+	// register admin endpoints
+	for pattern, handler := range adminHandlers {
+		mux.Handle(pattern, handler)
+	}
+
 	// redirect from / to /metrics
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/metrics", http.StatusFound)
@@ -169,3 +301,54 @@ func ObserveRequestDuration(operation string, duration float64) {
 func RecordRequest() {
 	requestsTotal.Inc()
 }
+
+// UpdatePeerLoad sets the number of in-flight requests to a peer
+func UpdatePeerLoad(peer string, load int64) {
+	peerLoad.WithLabelValues(peer).Set(float64(load))
+}
+
+// DeletePeerLoad drops the in-flight gauge of a peer that left the ring
+func DeletePeerLoad(peer string) {
+	peerLoad.DeleteLabelValues(peer)
+}
+
+// RecordLoadRedirect counts a key routed past its overloaded owner
+func RecordLoadRedirect() {
+	loadRedirects.Inc()
+}
+
+// RecordReplicaFallback counts a key served by a replica after its owner failed
+func RecordReplicaFallback() {
+	replicaFallbacks.Inc()
+}
+
+// RecordHandoff counts entries streamed to a new owner, accepted of them were stored
+func RecordHandoff(sent, accepted int) {
+	handoffEntries.WithLabelValues("sent").Add(float64(sent))
+	handoffEntries.WithLabelValues("accepted").Add(float64(accepted))
+}
+
+// RecordHotCacheHit counts a request served from the hot cache
+func RecordHotCacheHit() {
+	hotCacheHits.Inc()
+}
+
+// RecordHotCacheAdmission counts a value fetched from a peer copied into the hot cache
+func RecordHotCacheAdmission() {
+	hotCacheAdmissions.Inc()
+}
+
+// RecordStaleServed counts a value served past its expiry, while being revalidated or because loading it failed
+func RecordStaleServed(reason string) {
+	staleServed.WithLabelValues(reason).Inc()
+}
+
+// RecordEarlyRefresh counts an entry refreshed ahead of its expiry
+func RecordEarlyRefresh() {
+	earlyRefreshes.Inc()
+}
+
+// RecordBloomFiltered counts a lookup answered not found by the bloom filter
+func RecordBloomFiltered() {
+	bloomFiltered.Inc()
+}
@@ -1,27 +1,38 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"distcache/config"
 	"distcache/internal/bussiness/cnf/db"
 	"distcache/internal/cache"
 	"distcache/pkg/common/logger"
 	"distcache/pkg/etcd/discovery"
+	"distcache/pkg/registry"
 	"distcache/internal/metrics"
 )
 
 var (
 	port        = flag.Int("port", 9999, "service node port")
 	metricsPort = flag.Int("metricsPort", 2222, "metrics port")
+	weight      = flag.Int("weight", 0, "share of keys this node owns relative to its peers, overrides services.groupcache.weight")
 	loggerInstance = logger.NewLogger()
 )
 
-// shared updateChan is created for communication between NewServer(to add/update peers in the ring) AND the etcd dynamic node discovery!
-// first via DynamicServices the etcd starts to watch updates for nodes prefixed GroupCache
-// 2nd SetPeers set up the hash ring, grpcClients and the channels, e.g. update chan
-// 3rd Start() calls registerService, which register the svc/addr into etcd endpoints, it triggers DynamicServices on all the peers via update channel, which reconstructs the hash ring defined inside SetPeers
+// serviceVersion is announced in the registry metadata of the node.
+const serviceVersion = "v1.0.0"
+
+// NewServer and the registry node discovery work together:
+// first List the etcd (or static/file) registry for the nodes already registered under GroupCache
+// 2nd SetPeers set up the hash ring and grpcClients, then watches the registry for nodes joining and leaving
+// 3rd Start() calls registerService, which register the svc/addr into the registry, the registry Watch on all the peers
+// reports it and, once the membership settled, they add the node to the hash ring built inside SetPeers
 func main() {
 	config.InitConfig()
 	// Initialize database
@@ -30,23 +41,47 @@ func main() {
 	}
 	flag.Parse()
 
+	metrics.SetTopKeysSource(cache.TopKeyCounts)
+	metrics.HandleAdmin("/admin/hotkeys", cache.HotKeysHandler())
 	metrics.StartMetricsServer(*metricsPort)
 	loggerInstance.Infof("Metrics server started on port %d", *metricsPort)
 	serviceAddr := fmt.Sprintf("localhost:%d", *port)
 	gm := cache.NewGroupManager([]string{"metrics"}, serviceAddr)
+	metricsGroup, ok := gm["metrics"]
+	if !ok {
+		loggerInstance.Errorf("metrics group not created, invalid group manager config")
+		return
+	}
 
-	updateChan := make(chan struct{})
-	svr, err := cache.NewServer(updateChan, serviceAddr)
+	svc := config.Conf.Services["groupcache"]
+	reg, closeRegistry, err := newRegistry(svc)
 	if err != nil {
-		loggerInstance.Errorf("acquire grpc server instance failed, %v", err)
+		loggerInstance.Errorf("failed to create %q registry: %v", svc.Registry, err)
 		return
 	}
+	defer closeRegistry()
 
-	go discovery.DynamicServices(updateChan, config.Conf.Services["groupcache"].Name)
+	svr, err := cache.NewServer(serviceAddr, reg)
+	if err != nil {
+		loggerInstance.Errorf("acquire grpc server instance failed, %v", err)
+		return
+	}
+	nodeWeight := svc.Weight
+	if *weight > 0 {
+		nodeWeight = *weight
+	}
+	svr.SetMetadata(registry.Metadata{Weight: nodeWeight, Version: serviceVersion})
+	svr.SetLoadBound(svc.LoadBound)
+	svr.SetReplicationFactor(svc.Replicas)
+	svr.SetHandoffLimit(svc.HandoffLimit)
+	if err := svr.SetPeerSelector(svc.Selector); err != nil {
+		loggerInstance.Errorf("invalid peer selector: %v", err)
+		return
+	}
 
 	// check if there exists peers already, if so, we need to include them when initially SetPeers
 	// if not, SetPeers will be only the node itself
-	peers, err := discovery.ListServicePeers(config.Conf.Services["groupcache"].Name)
+	peers, err := reg.List(context.Background(), svc.Name)
 	if err != nil {
 		loggerInstance.Errorf("failed to discover peers: %v", err)
 		return
@@ -54,10 +89,56 @@ func main() {
 
 	svr.SetPeers(peers)
 
-	gm["metrics"].RegisterServer(svr)
+	metricsGroup.RegisterServer(svr)
+
+	// Warm the cache up with the keys this node owns from the last snapshot
+	cache.LoadSnapshots()
+
+	// On SIGINT or SIGTERM snapshot the caches, hand the hot entries off to their new owners and leave the ring,
+	// Start returns once the server stopped
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cache.SaveSnapshots()
+		if err := svr.Stop(); err != nil {
+			loggerInstance.Errorf("failed to stop server: %v", err)
+		}
+	}()
 
 	if err := svr.Start(); err != nil {
 		loggerInstance.Errorf("failed to start server: %v", err)
 		return
 	}
 }
+
+// leaseTTL returns how long the registration of a node outlives it: the lease ttl of the
+// service if set, else the etcd ttl. Zero keeps the registry default.
+func leaseTTL(svc *config.Service) time.Duration {
+	if svc.LeaseTTL > 0 {
+		return time.Duration(svc.LeaseTTL) * time.Second
+	}
+	return time.Duration(config.Conf.Etcd.TTL) * time.Second
+}
+
+// newRegistry creates the service registry selected in the configuration.
+// For etcd, one handle built from the configured endpoints is shared by registration and discovery,
+// the returned func closes it.
+func newRegistry(svc *config.Service) (registry.Registry, func(), error) {
+	switch svc.Registry {
+	case "", "etcd":
+		etcdCli, err := discovery.NewEtcdClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		reg := discovery.NewEtcdRegistry(etcdCli)
+		reg.SetLeaseTTL(leaseTTL(svc))
+		return reg, func() { etcdCli.Close() }, nil
+	case "static":
+		return registry.NewStatic(svc.Addr), func() {}, nil
+	case "file":
+		return registry.NewFile(svc.RegistryFile), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown registry %q, expected etcd, static or file", svc.Registry)
+	}
+}
@@ -2,11 +2,15 @@ package discovery
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 	"strings"
 
 	"distcache/config"
 	"distcache/pkg/common/logger"
+	"distcache/pkg/registry"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/naming/endpoints"
@@ -19,7 +23,8 @@ var loggerInstance = logger.NewLogger()
 
 // Discovery dials the specific peer address specified in the service string.
 // The service format should be "GroupCache/addr", where addr is like "127.0.0.1:2379".
-func Discovery(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+// Extra dial options, such as keepalive parameters, are appended to the defaults.
+func Discovery(c *clientv3.Client, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
     // Split the service string into two parts.
     parts := strings.SplitN(service, "/", 2)
 	// intial requests from client, where we only have serviceName in the request
@@ -32,10 +37,10 @@ func Discovery(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
 		// Note that the name of the service here must be consistent
 		// with the name of the service when it is registered.
 		loggerInstance.Infof("Discovery service is %s", service)
-		return grpc.NewClient("etcd:///"+service,
+		return grpc.NewClient("etcd:///"+service, append([]grpc.DialOption{
 			grpc.WithResolvers(etcdResolver),
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`))
+			grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`)}, opts...)...)
     }
 
     // redirected by remote peer, so we have both serviceName/peerAddr
@@ -44,23 +49,34 @@ func Discovery(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
     loggerInstance.Infof("Dialing direct target address: %s", targetAddr)
 
     // Directly dial the target address using grpc.Dial.
-    conn, err := grpc.Dial(targetAddr,
+    conn, err := grpc.Dial(targetAddr, append([]grpc.DialOption{
         grpc.WithTransportCredentials(insecure.NewCredentials()),
         // You can add additional options like grpc.WithBlock() if a blocking dial is required.
-    )
+    }, opts...)...)
     if err != nil {
         return nil, err
     }
     return conn, nil
 }
 
-// Go to the service registration center to find a list of
-// available service nodes based on the service name.
-func ListServicePeers(serviceName string) ([]string, error) {
+// NewEtcdClient creates the etcd handle shared by registration, discovery and
+// the peer clients from config.DefaultEtcdConfig.
+func NewEtcdClient() (*clientv3.Client, error) {
 	cli, err := clientv3.New(config.DefaultEtcdConfig)
 	if err != nil {
-		loggerInstance.Errorf("failed to connected to etcd, error: %v", err)
-		return []string{}, err
+		loggerInstance.Errorf("failed to connected to etcd %v, error: %v", config.DefaultEtcdConfig.Endpoints, err)
+		return nil, err
+	}
+	return cli, nil
+}
+
+// Go to the service registration center to find a list of
+// available service nodes based on the service name.
+// The metadata each node registered with, such as its weight, is parsed into the instance.
+// Endpoints with malformed metadata are logged and listed with default metadata.
+func ListServicePeers(cli *clientv3.Client, serviceName string) ([]registry.Instance, error) {
+	if cli == nil {
+		return []registry.Instance{}, fmt.Errorf("etcd client is required to list peers of %s", serviceName)
 	}
 
 	// Endpoints are actually ip:port combinations, which can also be regarded as socket in Unix.
@@ -68,7 +84,7 @@ func ListServicePeers(serviceName string) ([]string, error) {
 	endpointsManager, err := endpoints.NewManager(cli, serviceName)
 	if err != nil {
 		loggerInstance.Errorf("create endpoints manager failed, %v", err)
-		return []string{}, err
+		return []registry.Instance{}, err
 	}
 
 	// List returns all endpoints of the current service in the form of a map.
@@ -79,47 +95,39 @@ func ListServicePeers(serviceName string) ([]string, error) {
 	// loggerInstance.Infof("Key2EndpointMap: %+v", Key2EndpointMap)
 	if err != nil {
 		loggerInstance.Errorf("list endpoint nodes for target service failed, error: %s", err.Error())
-		return []string{}, err
+		return []registry.Instance{}, err
 	}
 
-	var peersAddr []string
+	var peers []registry.Instance
 	for key, endpoint := range Key2EndpointMap {
-		peersAddr = append(peersAddr, endpoint.Addr) // Addr is the server address on which a connection will be established.
-		loggerInstance.Infof("found endpoint addr: %s (%s):(%v)", key, endpoint.Addr, endpoint.Metadata)
+		// Addr is the server address on which a connection will be established.
+		peer := registry.Instance{Addr: endpoint.Addr}
+		if raw, err := json.Marshal(endpoint.Metadata); err == nil {
+			peer.Metadata, err = parseMetadata(raw)
+			if err != nil {
+				loggerInstance.Warnf("endpoint %s has invalid metadata %v: %v", key, endpoint.Metadata, err)
+			}
+		}
+		peers = append(peers, peer)
+		loggerInstance.Infof("found endpoint addr: %s (%s):(%v)", key, endpoint.Addr, peer.Metadata)
 	}
+	slices.SortFunc(peers, func(a, b registry.Instance) int { return strings.Compare(a.Addr, b.Addr) })
 
-	return peersAddr, nil
+	return peers, nil
 }
 
-// DynamicServices provides the ability to dynamically build global hash views
-// for the cache system and allowing for second-level view convergence.
-func DynamicServices(update chan struct{}, service string) {
-	cli, err := clientv3.New(config.DefaultEtcdConfig)
-	if err != nil {
-		loggerInstance.Errorf("failed to connected to etcd, error: %v", err)
-		return
-	}
-	defer cli.Close()
-
-	// Subscription and publishing mechanism.
-	// Can also be seen as an observer pattern.
-	// Monitor the changes of the {service} key or KV pairs prefixed with {service},
-	// and return the corresponding events, notify through the returned channel.
-	watchChan := cli.Watch(context.Background(), service, clientv3.WithPrefix())
-	loggerInstance.Infof("DynamicServices: Started watching for changes under service prefix %s", service)
-
-	// Each time a user adds or removes a new instance address to a given service, the watchChan backend daemon
-	// can scan for changes in the number of instances via WithPrefix() and return them as watchResp.Events events.
-	for watchResp := range watchChan {
-		for _, ev := range watchResp.Events {
-			switch ev.Type {
-			case clientv3.EventTypePut:
-				update <- struct{}{} //When a change occurs, send a signal to update channel telling endpoint manager to rebuild the hash map.
-				loggerInstance.Warnf("Service endpoint added or updated: %s", string(ev.Kv.Value))
-			case clientv3.EventTypeDelete:
-				update <- struct{}{} //When a change occurs, send a signal to update channel telling endpoint manager to rebuild the hash map.
-				loggerInstance.Warnf("Service endpoint removed: %s", string(ev.Kv.Key))
-			}
-		}
+// parseMetadata decodes the JSON metadata of an endpoint. Nodes register a
+// registry.Metadata object, older nodes the string "weight:10,version:v1.0.0".
+func parseMetadata(raw json.RawMessage) (registry.Metadata, error) {
+	var md registry.Metadata
+	var legacy string
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return md, nil
+	case json.Unmarshal(raw, &legacy) == nil:
+		return registry.ParseMetadata(legacy)
+	default:
+		err := json.Unmarshal(raw, &md)
+		return md, err
 	}
 }
@@ -2,26 +2,68 @@ package discovery
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
-	"distcache/config"
+	"distcache/pkg/registry"
 
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/naming/endpoints"
 )
 
-// Register registers the {addr} for the specified {service}. During normal service provision, this function will not return.
-// This is returned only when the 1. application is stopped 2. the lease renewal fails 3. the etcd connection is lost.
-func Register(service string, addr string, stop chan error) error {
-	cli, err := clientv3.New(config.DefaultEtcdConfig)
-	if err != nil {
-		loggerInstance.Errorf("err: %v", err)
-		return err
+var _ registry.Registry = (*EtcdRegistry)(nil)
+
+const defaultLeaseTTL = 5 // seconds
+
+// registration is a live endpoint registration kept alive by a lease.
+type registration struct {
+	leaseId clientv3.LeaseID
+	cancel  context.CancelFunc // stops the lease keepalive
+}
+
+// EtcdRegistry implements registry.Registry on top of the etcd endpoint manager.
+// The etcd client is shared with the caller and is not closed by the registry.
+type EtcdRegistry struct {
+	cli           *clientv3.Client
+	leaseTTL      int64 // seconds
+	mu            sync.Mutex
+	registrations map[string]registration // keyed by {service}/{addr}
+}
+
+// NewEtcdRegistry creates a registry storing endpoints in etcd through cli.
+func NewEtcdRegistry(cli *clientv3.Client) *EtcdRegistry {
+	return &EtcdRegistry{
+		cli:           cli,
+		leaseTTL:      defaultLeaseTTL,
+		registrations: make(map[string]registration),
 	}
+}
+
+// SetLeaseTTL sets how long an endpoint outlives its node when the keepalive stops,
+// e.g. after a crash. It applies to later registrations and is rounded up to whole seconds.
+func (r *EtcdRegistry) SetLeaseTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	r.leaseTTL = int64((ttl + time.Second - 1) / time.Second)
+}
 
-	//  Create a lease with a timeout of 5 seconds.
-	leaseGrantResp, err := cli.Grant(context.Background(), 5)
+// Register registers the {addr} for the specified {service} under a lease and keeps
+// the lease alive in the background until Deregister is called.
+// The metadata of inst is published with the endpoint.
+// If the keepalive fails, the endpoint is removed and peers drop the node from their ring.
+func (r *EtcdRegistry) Register(ctx context.Context, service string, inst registry.Instance) error {
+	addr := inst.Addr
+	if r.cli == nil {
+		return fmt.Errorf("etcd client is required to register %s", addr)
+	}
+
+	//  Create a lease with a timeout of leaseTTL seconds.
+	leaseGrantResp, err := r.cli.Grant(ctx, r.leaseTTL)
 	if err != nil {
 		return fmt.Errorf("grant creates a new lease failed: %v", err)
 	}
@@ -33,56 +75,215 @@ func Register(service string, addr string, stop chan error) error {
 
 	// Associate the service address with the lease and delete the service address information from etcd when the lease expires.
 	// If a service address wants to continue to provide services, it needs to renew the lease, which is also called lease keepalive.
-	err = etcdAddEndpoint(cli, leaseId, service, addr)
+	err = etcdAddEndpoint(r.cli, leaseId, service, inst)
 	if err != nil {
 		return fmt.Errorf("failed to add services as endpoint to etcd endpoint Manager: %v", err)
 	}
 
-	// KeepAlive attempts to keep the given lease alive forever.
+	// KeepAlive attempts to keep the given lease alive until the registration is cancelled.
 	// Each time a connected client receives a response from the keepalive channel,
 	// it can assume that the server has completed the renewal.
-	alive, err := cli.KeepAlive(context.Background(), leaseId)
+	keepaliveCtx, cancel := context.WithCancel(context.Background())
+	alive, err := r.cli.KeepAlive(keepaliveCtx, leaseId)
 	if err != nil {
+		cancel()
 		return fmt.Errorf("set keepalive for lease failed: %v", err)
 	}
 
+	r.mu.Lock()
+	if old, ok := r.registrations[service+"/"+addr]; ok {
+		old.cancel()
+	}
+	r.registrations[service+"/"+addr] = registration{leaseId: leaseId, cancel: cancel}
+	r.mu.Unlock()
+
+	go func() {
+		for range alive {
+			// Lease keepalive response, the lease was renewed.
+		}
+		if keepaliveCtx.Err() != nil {
+			return // Deregistered
+		}
+		loggerInstance.Error("keepalive channel closed, revoke given lease")
+		// Delete the endpoint from etcd
+		if err := etcdDelEndpoint(r.cli, service, addr); err != nil {
+			loggerInstance.Errorf("Failed to delete endpoint: %v", err)
+		}
+	}()
+
 	// During the lease period, the server corresponding to addr can provide services normally.
 	loggerInstance.Debugf("[%s] register service success", addr)
+	return nil
+}
+
+// Deregister stops the lease keepalive, deletes the endpoint and revokes its lease.
+func (r *EtcdRegistry) Deregister(ctx context.Context, service string, addr string) error {
+	r.mu.Lock()
+	reg, ok := r.registrations[service+"/"+addr]
+	delete(r.registrations, service+"/"+addr)
+	r.mu.Unlock()
+	if !ok {
+		return nil
+	}
 
+	reg.cancel()
+	if err := etcdDelEndpoint(r.cli, service, addr); err != nil {
+		return fmt.Errorf("failed to delete endpoint %s/%s: %v", service, addr, err)
+	}
+	if _, err := r.cli.Revoke(ctx, reg.leaseId); err != nil {
+		return fmt.Errorf("failed to revoke lease of %s/%s: %v", service, addr, err)
+	}
+	return nil
+}
+
+// List returns the instances registered for service.
+func (r *EtcdRegistry) List(_ context.Context, service string) ([]registry.Instance, error) {
+	return ListServicePeers(r.cli, service)
+}
+
+// Watch provides the ability to dynamically build global hash views
+// for the cache system and allowing for second-level view convergence.
+// It reports the difference between known and the current membership first,
+// then follows the etcd watch from the revision that membership was read at,
+// so that each address joining or leaving is reported exactly once. If the watch
+// breaks, e.g. because the etcd connection dropped, it resumes from the last revision
+// seen. If that revision has been compacted meanwhile, the membership is listed again
+// and the difference is reported instead.
+func (r *EtcdRegistry) Watch(ctx context.Context, service string, known []registry.Instance) (<-chan registry.Change, error) {
+	if r.cli == nil {
+		return nil, fmt.Errorf("etcd client is required to watch %s", service)
+	}
+
+	// Endpoints are stored under {service}/{addr}
+	prefix := service + "/"
+	members, rev, err := r.members(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list endpoints of %s: %v", service, err)
+	}
+
+	stream := registry.NewStream(ctx, 0)
+	stream.Push(registry.Diff(known, sortedInstances(members)))
+	go r.watch(ctx, prefix, members, rev, stream)
+	loggerInstance.Infof("Watch: Started watching for changes under service prefix %s from revision %d", service, rev)
+	return stream.C(), nil
+}
+
+// members returns the instances registered under prefix, keyed by address,
+// and the revision they were read at.
+func (r *EtcdRegistry) members(ctx context.Context, prefix string) (map[string]registry.Metadata, int64, error) {
+	resp, err := r.cli.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	members := make(map[string]registry.Metadata, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		inst := decodeEndpoint(prefix, kv.Key, kv.Value)
+		members[inst.Addr] = inst.Metadata
+	}
+	return members, resp.Header.Revision, nil
+}
+
+// decodeEndpoint returns the instance stored by the endpoint manager under key.
+// The address is taken from the key, so a value that cannot be decoded only loses the metadata.
+func decodeEndpoint(prefix string, key []byte, value []byte) registry.Instance {
+	inst := registry.Instance{Addr: strings.TrimPrefix(string(key), prefix)}
+	var endpoint struct {
+		Metadata json.RawMessage
+	}
+	if err := json.Unmarshal(value, &endpoint); err != nil {
+		loggerInstance.Warnf("endpoint %s has an invalid value: %v", key, err)
+		return inst
+	}
+	md, err := parseMetadata(endpoint.Metadata)
+	if err != nil {
+		loggerInstance.Warnf("endpoint %s has invalid metadata %s: %v", key, endpoint.Metadata, err)
+	}
+	inst.Metadata = md
+	return inst
+}
+
+// watch pushes the changes under prefix after revision rev to stream until ctx is done.
+// Each attempt watches under its own context, canceled before resuming.
+func (r *EtcdRegistry) watch(ctx context.Context, prefix string, members map[string]registry.Metadata, rev int64, stream *registry.Stream) {
 	for {
-		select {
-		case err := <-stop: // Application-level stop signal.
-			return err
-		case <-cli.Ctx().Done(): // Etcd client broken.
-			return fmt.Errorf("etcd client connect broken")
-		case _, ok := <-alive: // Lease keepalive response.
-			if !ok {
-				loggerInstance.Error("keepalive channel closed, revoke given lease")
-				// Delete the endpoint from etcd
-				if err := etcdDelEndpoint(cli, service, addr); err != nil {
-					loggerInstance.Errorf("Failed to delete endpoint: %v", err)
+		// Subscription and publishing mechanism.
+		// Monitor the KV pairs prefixed with {service}/ from the revision after the last one seen.
+		// WithRequireLeader closes the watch when the member loses its leader instead of hanging.
+		watchCtx, cancel := context.WithCancel(ctx)
+		watchChan := r.cli.Watch(clientv3.WithRequireLeader(watchCtx), prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		for watchResp := range watchChan {
+			if watchResp.CompactRevision != 0 {
+				// The events since rev are gone, compare against a fresh listing
+				loggerInstance.Warnf("Watch: revision %d of %s compacted, listing endpoints again", rev+1, prefix)
+				current, currentRev, err := r.members(ctx, prefix)
+				if err != nil {
+					loggerInstance.Errorf("Watch: failed to list endpoints of %s: %v", prefix, err)
+					break
+				}
+				stream.Push(registry.Diff(sortedInstances(members), sortedInstances(current)))
+				members, rev = current, currentRev
+				break
+			}
+			if err := watchResp.Err(); err != nil {
+				loggerInstance.Warnf("Watch: watch on %s failed: %v", prefix, err)
+				break
+			}
+
+			var change registry.Change
+			for _, ev := range watchResp.Events {
+				inst := decodeEndpoint(prefix, ev.Kv.Key, ev.Kv.Value)
+				md, known := members[inst.Addr]
+				switch {
+				case ev.Type == clientv3.EventTypePut && (!known || md != inst.Metadata):
+					loggerInstance.Warnf("Service endpoint added or updated: %s", inst)
+					members[inst.Addr] = inst.Metadata
+					change.Added = append(change.Added, inst)
+				case ev.Type == clientv3.EventTypeDelete && known:
+					loggerInstance.Warnf("Service endpoint removed: %s", inst.Addr)
+					delete(members, inst.Addr)
+					change.Removed = append(change.Removed, inst.Addr)
 				}
-				return fmt.Errorf("keepalive channel closed, revoke given lease") //If a non-nil error is returned, the upper layer will close stopsChan  which in turn shuts down the server.
 			}
-		default:
-			time.Sleep(2 * time.Second) // Prevent excessive resource usage.
+			rev = watchResp.Header.Revision
+			stream.Push(change)
 		}
+		// Releases the etcd watch left behind by a break
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+		loggerInstance.Warnf("Watch: watch on %s interrupted, resuming from revision %d", prefix, rev+1)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// sortedInstances returns the instances in members by ascending address.
+func sortedInstances(members map[string]registry.Metadata) []registry.Instance {
+	instances := make([]registry.Instance, 0, len(members))
+	for addr, md := range members {
+		instances = append(instances, registry.Instance{Addr: addr, Metadata: md})
 	}
+	slices.SortFunc(instances, func(a, b registry.Instance) int { return strings.Compare(a.Addr, b.Addr) })
+	return instances
 }
 
 // The registration information for the service endpoint is stored in etcd as a key value.
 // the form of key is {service}/{addr},
-// the form of value is {addr, metadata}.
-func etcdAddEndpoint(client *clientv3.Client, leaseId clientv3.LeaseID, service string, addr string) error {
+// the form of value is {addr, metadata}, with metadata a registry.Metadata object.
+func etcdAddEndpoint(client *clientv3.Client, leaseId clientv3.LeaseID, service string, inst registry.Instance) error {
 	endpointsManager, err := endpoints.NewManager(client, service)
 	if err != nil {
 		return err
 	}
 
-	// Use string metadata to ensure comparability.
 	metadata := endpoints.Endpoint{
-		Addr:     addr,
-		Metadata: "weight:10,version:v1.0.0",
+		Addr:     inst.Addr,
+		Metadata: inst.Metadata,
 	}
 	// loggerInstance.Infof("metadata is %+v", metadata)
 
@@ -90,7 +291,7 @@ func etcdAddEndpoint(client *clientv3.Client, leaseId clientv3.LeaseID, service
 	// Metadata is the information associated with Addr, which may be used to make load balancing decision.
 	// Endpoint represents a single address the connection can be established with.
 	return endpointsManager.AddEndpoint(context.TODO(),
-		fmt.Sprintf("%s/%s", service, addr),
+		fmt.Sprintf("%s/%s", service, inst.Addr),
 		metadata,
 		clientv3.WithLease(leaseId))
 }
@@ -0,0 +1,177 @@
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Change describes how the instances of a service changed.
+type Change struct {
+	Added   []Instance // instances that joined or changed their metadata, by ascending address
+	Removed []string   // addresses that left, in ascending order
+}
+
+// Empty reports whether the change adds or removes nothing.
+func (c Change) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0
+}
+
+// Diff returns the change turning the instance list from into to.
+func Diff(from, to []Instance) Change {
+	from, to = sorted(from), sorted(to)
+	var c Change
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case j == len(to) || (i < len(from) && from[i].Addr < to[j].Addr):
+			c.Removed = append(c.Removed, from[i].Addr)
+			i++
+		case i == len(from) || to[j].Addr < from[i].Addr:
+			c.Added = append(c.Added, to[j])
+			j++
+		default:
+			if from[i].Metadata != to[j].Metadata {
+				c.Added = append(c.Added, to[j])
+			}
+			i++
+			j++
+		}
+	}
+	return c
+}
+
+// Stream delivers changes to a slow receiver without ever blocking the sender.
+// Changes that pile up are merged into one that keeps the last change of every
+// address, so a receiver applying it ends up with the same membership as one
+// applying them all. With a window, a change is only delivered once no further
+// change arrived for that long.
+type Stream struct {
+	mu      sync.Mutex
+	added   map[string]Metadata
+	removed map[string]struct{}
+	pending chan struct{}
+	out     chan Change
+	window  time.Duration
+}
+
+// NewStream starts delivering pushed changes on C until ctx is done.
+func NewStream(ctx context.Context, window time.Duration) *Stream {
+	s := &Stream{
+		added:   make(map[string]Metadata),
+		removed: make(map[string]struct{}),
+		pending: make(chan struct{}, 1),
+		out:     make(chan Change),
+		window:  window,
+	}
+	go s.run(ctx)
+	return s
+}
+
+// Push merges c into the changes not yet delivered.
+func (s *Stream) Push(c Change) {
+	if c.Empty() {
+		return
+	}
+
+	// An address that leaves and joins again may have changed its metadata,
+	// so it is reported as added rather than cancelled out. One that joins
+	// and leaves again is reported as removed, it may have been known before.
+	s.mu.Lock()
+	for _, inst := range c.Added {
+		delete(s.removed, inst.Addr)
+		s.added[inst.Addr] = inst.Metadata
+	}
+	for _, addr := range c.Removed {
+		delete(s.added, addr)
+		s.removed[addr] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	notify(s.pending)
+}
+
+// take returns and resets the merged change.
+func (s *Stream) take() Change {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := Change{Added: sortedInstances(s.added), Removed: sortedKeys(s.removed)}
+	if len(c.Added) == 0 {
+		c.Added = nil
+	}
+	if len(c.Removed) == 0 {
+		c.Removed = nil
+	}
+	clear(s.added)
+	clear(s.removed)
+	return c
+}
+
+// C returns the channel changes are delivered on. It is closed once ctx is done.
+func (s *Stream) C() <-chan Change {
+	return s.out
+}
+
+func (s *Stream) run(ctx context.Context) {
+	defer close(s.out)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.pending:
+		}
+
+		// Wait for the membership to settle before delivering
+		if s.window > 0 {
+			timer := time.NewTimer(s.window)
+		settle:
+			for {
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				case <-s.pending:
+					timer.Reset(s.window)
+				case <-timer.C:
+					break settle
+				}
+			}
+		}
+
+		c := s.take()
+		if c.Empty() {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case s.out <- c:
+		}
+	}
+}
+
+// Debounce merges the changes read from in and delivers them once no further
+// change arrived for window. During a rolling restart of N nodes this turns 2N
+// changes into a single one. The returned channel is closed when ctx is done
+// or in is closed.
+func Debounce(ctx context.Context, in <-chan Change, window time.Duration) <-chan Change {
+	ctx, cancel := context.WithCancel(ctx)
+	s := NewStream(ctx, window)
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case c, ok := <-in:
+				if !ok {
+					return
+				}
+				s.Push(c)
+			}
+		}
+	}()
+	return s.C()
+}
@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Registry = (*File)(nil)
+
+const defaultFilePollInterval = time.Second
+
+// File is a Registry backed by a plain text file, typically maintained by an
+// operator or a configuration management tool. Every line holds one instance
+// as {service}/{addr}, the same form etcd uses for its keys, optionally
+// followed by its metadata as in "GroupCache/127.0.0.1:9999 weight:20".
+// Empty lines and lines starting with # are ignored.
+//
+// The file is polled for changes, so edits made by any process are picked up.
+// Register and Deregister rewrite the file atomically but do not coordinate
+// with other writers.
+type File struct {
+	path     string
+	interval time.Duration
+	mu       sync.Mutex // serializes writes of this process
+}
+
+// NewFile creates a registry reading path. The file does not need to exist yet.
+func NewFile(path string) *File {
+	return &File{path: path, interval: defaultFilePollInterval}
+}
+
+// SetPollInterval sets how often Watch checks the file for changes.
+func (f *File) SetPollInterval(interval time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.interval = interval
+}
+
+// Register adds a {service}/{addr} line to the file, replacing the line
+// already present for addr.
+func (f *File) Register(_ context.Context, service string, inst Instance) error {
+	return f.update(func(lines []string) []string {
+		entry := service + "/" + inst.String()
+		if i := slices.IndexFunc(lines, isEntry(service, inst.Addr)); i >= 0 {
+			lines[i] = entry
+			return lines
+		}
+		return append(lines, entry)
+	})
+}
+
+// Deregister removes the {service}/{addr} line from the file.
+func (f *File) Deregister(_ context.Context, service string, addr string) error {
+	return f.update(func(lines []string) []string {
+		return slices.DeleteFunc(lines, isEntry(service, addr))
+	})
+}
+
+// List returns the instances listed for service by ascending address.
+// A missing file lists no instances, malformed lines are logged and skipped.
+func (f *File) List(_ context.Context, service string) ([]Instance, error) {
+	lines, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]Metadata)
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s, entry, ok := strings.Cut(line, "/")
+		if !ok || s != service {
+			continue
+		}
+		inst, err := ParseInstance(entry)
+		if err != nil {
+			loggerInstance.Warnf("file registry: ignoring line %q of %s: %v", line, f.path, err)
+			continue
+		}
+		instances[inst.Addr] = inst.Metadata
+	}
+	return sortedInstances(instances), nil
+}
+
+// isEntry returns a func reporting whether a line registers addr for service.
+func isEntry(service string, addr string) func(line string) bool {
+	return func(line string) bool {
+		entry, ok := strings.CutPrefix(line, service+"/")
+		if !ok {
+			return false
+		}
+		a, _, _ := strings.Cut(entry, " ")
+		return a == addr
+	}
+}
+
+// Watch polls the file and reports the addresses added to or removed from service.
+func (f *File) Watch(ctx context.Context, service string, known []Instance) (<-chan Change, error) {
+	last, err := f.List(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	interval := f.interval
+	f.mu.Unlock()
+
+	s := NewStream(ctx, 0)
+	s.Push(Diff(known, last))
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				addrs, err := f.List(ctx, service)
+				if err != nil {
+					loggerInstance.Warnf("file registry: failed to read %s: %v", f.path, err)
+					continue
+				}
+				s.Push(Diff(last, addrs))
+				last = addrs
+			}
+		}
+	}()
+	return s.C(), nil
+}
+
+// read returns the lines of the file with surrounding whitespace trimmed.
+// Comments and empty lines are kept so that updates preserve them.
+func (f *File) read() ([]string, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry file %s: %w", f.path, err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	return lines, scanner.Err()
+}
+
+// update applies fn to the lines of the file and writes the result through a
+// temporary file, so readers never see a partially written file.
+func (f *File) update(fn func(lines []string) []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lines, err := f.read()
+	if err != nil {
+		return err
+	}
+	lines = fn(lines)
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to update registry file %s: %w", f.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(tmp, line); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to update registry file %s: %w", f.path, err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to update registry file %s: %w", f.path, err)
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,129 @@
+package registry
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultWeight is the weight of an instance that does not announce one.
+// An instance of weight 2*DefaultWeight owns about twice as many keys.
+const DefaultWeight = 10
+
+// Metadata is what an instance announces about itself when it registers.
+// It is stored as the endpoint metadata in etcd and after the address in the
+// file and static registries, written as weight:20,version:v1.0.0.
+type Metadata struct {
+	Weight  int    `json:"weight,omitempty"`  // relative capacity, 0 means DefaultWeight
+	Version string `json:"version,omitempty"` // version of the cache node
+}
+
+// Instance is one address serving a service.
+type Instance struct {
+	Addr string `json:"addr"`
+	Metadata
+}
+
+// EffectiveWeight returns the weight to use for md, DefaultWeight if none was set.
+func (md Metadata) EffectiveWeight() int {
+	if md.Weight <= 0 {
+		return DefaultWeight
+	}
+	return md.Weight
+}
+
+// String formats md as weight:20,version:v1.0.0, leaving out unset fields.
+func (md Metadata) String() string {
+	var fields []string
+	if md.Weight != 0 {
+		fields = append(fields, "weight:"+strconv.Itoa(md.Weight))
+	}
+	if md.Version != "" {
+		fields = append(fields, "version:"+md.Version)
+	}
+	return strings.Join(fields, ",")
+}
+
+// String formats inst as its address followed by its metadata, if any.
+func (inst Instance) String() string {
+	if md := inst.Metadata.String(); md != "" {
+		return inst.Addr + " " + md
+	}
+	return inst.Addr
+}
+
+// ParseMetadata reads metadata formatted by Metadata.String.
+// Both : and = separate a field from its value, unknown fields are ignored.
+func ParseMetadata(s string) (Metadata, error) {
+	var md Metadata
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name, value, ok := strings.Cut(field, ":")
+		if !ok {
+			name, value, ok = strings.Cut(field, "=")
+		}
+		if !ok {
+			return Metadata{}, fmt.Errorf("invalid metadata field %q, expected name:value", field)
+		}
+		switch strings.TrimSpace(name) {
+		case "weight":
+			weight, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || weight < 0 {
+				return Metadata{}, fmt.Errorf("invalid weight %q", value)
+			}
+			md.Weight = weight
+		case "version":
+			md.Version = strings.TrimSpace(value)
+		}
+	}
+	return md, nil
+}
+
+// ParseInstance reads an instance formatted by Instance.String.
+func ParseInstance(s string) (Instance, error) {
+	addr, md, _ := strings.Cut(strings.TrimSpace(s), " ")
+	if addr == "" {
+		return Instance{}, fmt.Errorf("missing address in %q", s)
+	}
+	metadata, err := ParseMetadata(md)
+	if err != nil {
+		return Instance{}, fmt.Errorf("instance %s: %w", addr, err)
+	}
+	return Instance{Addr: addr, Metadata: metadata}, nil
+}
+
+// Addrs returns the addresses of instances.
+func Addrs(instances []Instance) []string {
+	addrs := make([]string, len(instances))
+	for i, inst := range instances {
+		addrs[i] = inst.Addr
+	}
+	return addrs
+}
+
+// sortInstances orders instances by address.
+func sortInstances(instances []Instance) {
+	slices.SortFunc(instances, func(a, b Instance) int { return cmp.Compare(a.Addr, b.Addr) })
+}
+
+// sorted returns instances in ascending order of address, sorting a copy
+// unless they already are.
+func sorted(instances []Instance) []Instance {
+	if slices.IsSortedFunc(instances, func(a, b Instance) int { return cmp.Compare(a.Addr, b.Addr) }) {
+		return instances
+	}
+	instances = slices.Clone(instances)
+	sortInstances(instances)
+	return instances
+}
+
+// sortedInstances returns the instances of set, keyed by address, in ascending order.
+func sortedInstances(set map[string]Metadata) []Instance {
+	instances := make([]Instance, 0, len(set))
+	for addr, md := range set {
+		instances = append(instances, Instance{Addr: addr, Metadata: md})
+	}
+	sortInstances(instances)
+	return instances
+}
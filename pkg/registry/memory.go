@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+var _ Registry = (*Memory)(nil)
+
+// Memory is a Registry kept in process memory.
+// It lets several nodes of a test share one view of the cluster without etcd.
+type Memory struct {
+	mu       sync.Mutex
+	services map[string]map[string]Metadata
+	watchers map[string][]*Stream
+}
+
+// NewMemory creates an empty in-memory registry.
+func NewMemory() *Memory {
+	return &Memory{
+		services: make(map[string]map[string]Metadata),
+		watchers: make(map[string][]*Stream),
+	}
+}
+
+// Register adds inst to service and notifies the watchers of service.
+func (m *Memory) Register(_ context.Context, service string, inst Instance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instances, ok := m.services[service]
+	if !ok {
+		instances = make(map[string]Metadata)
+		m.services[service] = instances
+	}
+	if md, exists := instances[inst.Addr]; exists && md == inst.Metadata {
+		return nil
+	}
+	instances[inst.Addr] = inst.Metadata
+	m.pushLocked(service, Change{Added: []Instance{inst}})
+	return nil
+}
+
+// Deregister removes addr from service and notifies the watchers of service.
+func (m *Memory) Deregister(_ context.Context, service string, addr string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.services[service][addr]; !exists {
+		return nil
+	}
+	delete(m.services[service], addr)
+	m.pushLocked(service, Change{Removed: []string{addr}})
+	return nil
+}
+
+// List returns the instances registered for service by ascending address.
+func (m *Memory) List(_ context.Context, service string) ([]Instance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedInstances(m.services[service]), nil
+}
+
+// Watch returns a channel receiving every Register or Deregister of service.
+func (m *Memory) Watch(ctx context.Context, service string, known []Instance) (<-chan Change, error) {
+	s := NewStream(ctx, 0)
+
+	m.mu.Lock()
+	s.Push(Diff(known, sortedInstances(m.services[service])))
+	m.watchers[service] = append(m.watchers[service], s)
+	m.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		watchers := m.watchers[service]
+		for i, w := range watchers {
+			if w == s {
+				m.watchers[service] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+	}()
+	return s.C(), nil
+}
+
+// pushLocked sends c to all watchers of service, m.mu must be held.
+func (m *Memory) pushLocked(service string, c Change) {
+	for _, s := range m.watchers[service] {
+		s.Push(c)
+	}
+}
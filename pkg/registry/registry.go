@@ -0,0 +1,55 @@
+// Package registry defines how cache nodes find each other.
+// A Registry keeps the list of addresses serving a service. Besides the etcd
+// implementation in pkg/etcd/discovery it provides a static list taken from
+// the configuration, a file watched for changes and an in-memory registry for tests.
+package registry
+
+import (
+	"context"
+	"sort"
+
+	"distcache/pkg/common/logger"
+)
+
+var loggerInstance = logger.NewLogger()
+
+// Registry is the interface implemented by service discovery backends.
+type Registry interface {
+	// Register announces inst as an instance of service. Registering the same
+	// address again replaces its metadata.
+	// The registration lasts until Deregister is called or the process dies.
+	Register(ctx context.Context, service string, inst Instance) error
+
+	// Deregister removes addr from the instances of service.
+	Deregister(ctx context.Context, service string, addr string) error
+
+	// List returns the instances currently registered for service, by ascending address.
+	List(ctx context.Context, service string) ([]Instance, error)
+
+	// Watch returns a channel that receives the instances joining, changing
+	// and leaving service, starting with the difference between known, usually
+	// the result of an earlier List, and the instances registered when the watch
+	// starts: a change between List and Watch is not missed. Changes are merged
+	// while the receiver is busy, so a slow receiver never blocks the registry.
+	// The channel is closed once ctx is done.
+	Watch(ctx context.Context, service string, known []Instance) (<-chan Change, error)
+}
+
+// notify sends a signal to ch without blocking, a pending signal already
+// tells the receiver to look again.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// sortedKeys returns the keys of set in ascending order.
+func sortedKeys(set map[string]struct{}) []string {
+	addrs := make([]string, 0, len(set))
+	for addr := range set {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
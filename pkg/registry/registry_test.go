@@ -0,0 +1,253 @@
+package registry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// waitChange merges the changes received on ch until they add up to want.
+// It fails the test if that does not happen within a second.
+func waitChange(t *testing.T, ch <-chan Change, want Change) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	merged := NewStream(ctx, 0)
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case c := <-ch:
+			merged.Push(c)
+			got := merged.take()
+			if slices.Equal(got.Added, want.Added) && slices.Equal(got.Removed, want.Removed) {
+				return
+			}
+			merged.Push(got)
+		case <-timeout:
+			t.Fatalf("changes did not add up to %+v", want)
+		}
+	}
+}
+
+// testRegistry runs the behaviour shared by all writable registries.
+func testRegistry(t *testing.T, r Registry) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := r.Watch(ctx, "GroupCache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heavy := Instance{Addr: "127.0.0.1:10000", Metadata: Metadata{Weight: 20, Version: "v1.0.0"}}
+	for _, inst := range []Instance{heavy, {Addr: "127.0.0.1:9999"}, {Addr: "127.0.0.1:9999"}} {
+		if err := r.Register(ctx, "GroupCache", inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Register(ctx, "Other", Instance{Addr: "127.0.0.1:8888"}); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, changes, Change{Added: []Instance{heavy, {Addr: "127.0.0.1:9999"}}})
+
+	instances, err := r.List(ctx, "GroupCache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Instance{heavy, {Addr: "127.0.0.1:9999"}}; !slices.Equal(instances, want) {
+		t.Errorf("List() = %v, want %v", instances, want)
+	}
+
+	// Registering again replaces the metadata
+	heavy.Weight = 30
+	if err := r.Register(ctx, "GroupCache", heavy); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, changes, Change{Added: []Instance{heavy}})
+
+	if err := r.Deregister(ctx, "GroupCache", "127.0.0.1:10000"); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, changes, Change{Removed: []string{"127.0.0.1:10000"}})
+	if instances, _ := r.List(ctx, "GroupCache"); !slices.Equal(Addrs(instances), []string{"127.0.0.1:9999"}) {
+		t.Errorf("List() after Deregister = %v, want [127.0.0.1:9999]", instances)
+	}
+
+	cancel()
+	for range changes {
+		// drain until the watch channel is closed
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testRegistry(t, NewMemory())
+}
+
+func TestWatch_FromKnown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	for name, r := range map[string]Registry{"memory": NewMemory(), "file": NewFile(path)} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if err := r.Register(ctx, "GroupCache", Instance{Addr: "127.0.0.1:9999"}); err != nil {
+				t.Fatal(err)
+			}
+			known, err := r.List(ctx, "GroupCache")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Registered between List and Watch
+			if err := r.Register(ctx, "GroupCache", Instance{Addr: "127.0.0.1:10000"}); err != nil {
+				t.Fatal(err)
+			}
+
+			changes, err := r.Watch(ctx, "GroupCache", known)
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitChange(t, changes, Change{Added: []Instance{{Addr: "127.0.0.1:10000"}}})
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	if err := os.WriteFile(path, []byte("# cache nodes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewFile(path)
+	r.SetPollInterval(10 * time.Millisecond)
+	testRegistry(t, r)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# cache nodes\nGroupCache/127.0.0.1:9999\nOther/127.0.0.1:8888\n"; string(data) != want {
+		t.Errorf("file content = %q, want %q", data, want)
+	}
+}
+
+func TestFile_ExternalEdit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	r := NewFile(path)
+	r.SetPollInterval(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if instances, err := r.List(ctx, "GroupCache"); err != nil || len(instances) != 0 {
+		t.Fatalf("List() of a missing file = %v, %v; want no instances", instances, err)
+	}
+
+	changes, err := r.Watch(ctx, "GroupCache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("GroupCache/127.0.0.1:9999\nGroupCache/127.0.0.1:10000 weight:20\nGroupCache/bad weight:x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitChange(t, changes, Change{Added: []Instance{{Addr: "127.0.0.1:10000", Metadata: Metadata{Weight: 20}}, {Addr: "127.0.0.1:9999"}}})
+}
+
+func TestStatic(t *testing.T) {
+	r := NewStatic([]string{"127.0.0.1:9999", "127.0.0.1:10000 weight:20"})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	changes, err := r.Watch(ctx, "GroupCache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(ctx, "GroupCache", Instance{Addr: "127.0.0.1:10001"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []Instance{{Addr: "127.0.0.1:10000", Metadata: Metadata{Weight: 20}}, {Addr: "127.0.0.1:9999"}}
+	if instances, _ := r.List(ctx, "GroupCache"); !slices.Equal(instances, want) {
+		t.Errorf("List() = %v, static membership should not change", instances)
+	}
+
+	cancel()
+	if _, ok := <-changes; ok {
+		t.Error("static registry should never signal a change")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := []Instance{{Addr: "a"}, {Addr: "b"}, {Addr: "d"}}
+	to := []Instance{{Addr: "b", Metadata: Metadata{Weight: 20}}, {Addr: "c"}, {Addr: "d"}, {Addr: "e"}}
+	got := Diff(from, to)
+	if want := []string{"b", "c", "e"}; !slices.Equal(Addrs(got.Added), want) || !slices.Equal(got.Removed, []string{"a"}) {
+		t.Errorf("Diff() = %+v, want added %v removed [a]", got, want)
+	}
+	if !Diff(to, to).Empty() {
+		t.Error("Diff of equal lists should be empty")
+	}
+	if got := Diff(nil, []Instance{{Addr: "b"}, {Addr: "a"}}); !slices.Equal(Addrs(got.Added), []string{"a", "b"}) {
+		t.Errorf("Diff() of an unsorted list = %+v, want added [a b]", got)
+	}
+}
+
+func TestParseInstance(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Instance
+		wantErr bool
+	}{
+		{in: "127.0.0.1:9999", want: Instance{Addr: "127.0.0.1:9999"}},
+		{in: "127.0.0.1:9999 weight:20,version:v1.0.0", want: Instance{Addr: "127.0.0.1:9999", Metadata: Metadata{Weight: 20, Version: "v1.0.0"}}},
+		{in: " 127.0.0.1:9999 weight=5 zone:a ", want: Instance{Addr: "127.0.0.1:9999", Metadata: Metadata{Weight: 5}}},
+		{in: "127.0.0.1:9999 weight:-1", wantErr: true},
+		{in: "127.0.0.1:9999 weight", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseInstance(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseInstance(%q) = %+v, %v; want %+v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil {
+			if again, _ := ParseInstance(got.String()); again != got {
+				t.Errorf("ParseInstance(%q.String()) = %+v, want %+v", got, again, got)
+			}
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan Change)
+	out := Debounce(ctx, in, 50*time.Millisecond)
+
+	// A rolling restart: every node leaves and joins again, one new node joins
+	// and one joins only to leave again
+	for _, addr := range []string{"a", "b", "c"} {
+		in <- Change{Removed: []string{addr}}
+		in <- Change{Added: []Instance{{Addr: addr}}}
+	}
+	in <- Change{Added: []Instance{{Addr: "d"}}}
+	in <- Change{Added: []Instance{{Addr: "e"}}}
+	in <- Change{Removed: []string{"e"}}
+
+	select {
+	case c := <-out:
+		if want := []string{"a", "b", "c", "d"}; !slices.Equal(Addrs(c.Added), want) || !slices.Equal(c.Removed, []string{"e"}) {
+			t.Errorf("Debounce() delivered %+v, want added %v removed [e]", c, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("debounced change not delivered")
+	}
+
+	select {
+	case c := <-out:
+		t.Errorf("Debounce() delivered a second change %+v", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
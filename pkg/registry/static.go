@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"context"
+)
+
+var _ Registry = (*Static)(nil)
+
+// Static is a Registry backed by a fixed list of addresses, such as
+// services.groupcache.addr in config.yml. Membership never changes:
+// Register and Deregister are no-ops and Watch never signals.
+type Static struct {
+	instances []Instance
+}
+
+// NewStatic creates a registry that always lists addrs. An address may be
+// followed by its metadata, as in "127.0.0.1:9999 weight:20". Entries that
+// cannot be parsed are logged and left out.
+func NewStatic(addrs []string) *Static {
+	instances := make([]Instance, 0, len(addrs))
+	for _, addr := range addrs {
+		inst, err := ParseInstance(addr)
+		if err != nil {
+			loggerInstance.Warnf("static registry: ignoring %q: %v", addr, err)
+			continue
+		}
+		instances = append(instances, inst)
+	}
+	sortInstances(instances)
+	return &Static{instances: instances}
+}
+
+// Register does nothing, the node is expected to be in the static list.
+func (s *Static) Register(_ context.Context, service string, inst Instance) error {
+	for _, listed := range s.instances {
+		if listed.Addr == inst.Addr {
+			return nil
+		}
+	}
+	loggerInstance.Warnf("static registry: %s is not listed for service %s, peers will not route to it", inst.Addr, service)
+	return nil
+}
+
+// Deregister does nothing.
+func (s *Static) Deregister(context.Context, string, string) error {
+	return nil
+}
+
+// List returns the static instances for every service.
+func (s *Static) List(context.Context, string) ([]Instance, error) {
+	return append([]Instance(nil), s.instances...), nil
+}
+
+// Watch returns a channel that is closed once ctx is done and never receives a change.
+// The instances are fixed, known is expected to be the result of List.
+func (s *Static) Watch(ctx context.Context, _ string, _ []Instance) (<-chan Change, error) {
+	ch := make(chan Change)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
@@ -22,7 +22,6 @@ import (
 )
 
 const (
-	ErrRPCCallNotFound  = "rpc error: code = Unknown desc = record not found"
 	MaxRetries          = 3
 	InitialRetryWaitSec = 1
 )
@@ -207,7 +206,7 @@ func main() {
 }
 
 func ErrorHandle(err error) Status {
-	if err.Error() == ErrRPCCallNotFound {
+	if status.Code(err) == codes.NotFound {
 		return NotFoundStatus
 	}
 	return ErrorStatus